@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// extractMMDB replaces the download at path with the MMDB it holds when it
+// is compressed or an archive, as some mirrors and MaxMind publish them:
+// gzip streams are decompressed and tar and zip archives give up their first
+// .mmdb file, also when the tar is gzipped. Anything else is left alone for
+// validateMMDB to judge. No more than limit bytes are extracted.
+func extractMMDB(path string, limit int64) error {
+	// A gzipped tar takes two rounds.
+	for range 3 {
+		kind, err := archiveKind(path)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case "gzip":
+			err = gunzipFile(path, limit)
+		case "tar":
+			err = untarMMDB(path, limit)
+		case "zip":
+			err = unzipMMDB(path, limit)
+		default:
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("extract the %s download: %w", kind, err)
+		}
+	}
+	return fmt.Errorf("extract the download: nested too deeply")
+}
+
+// archiveKind tells from its first bytes whether the file at path is a gzip
+// stream, a tar or zip archive, or none of them ("").
+func archiveKind(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return "gzip", nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "zip", nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return "tar", nil
+	}
+	return "", nil
+}
+
+func gunzipFile(path string, limit int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	logInfo("Decompressing the gzipped download.")
+	return writeExtracted(path, zr, limit)
+}
+
+// untarMMDB replaces the tar archive at path, as MaxMind packs its
+// databases, with the first .mmdb file inside it.
+func untarMMDB(path string, limit int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("no .mmdb file in the archive")
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeReg && strings.HasSuffix(h.Name, ".mmdb") {
+			logInfo("Extracting " + h.Name + " from the archive.")
+			return writeExtracted(path, tr, limit)
+		}
+	}
+}
+
+// unzipMMDB replaces the zip archive at path with the first .mmdb file
+// inside it.
+func unzipMMDB(p string, limit int64) error {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || path.Ext(zf.Name) != ".mmdb" {
+			continue
+		}
+		logInfo("Extracting " + zf.Name + " from the archive.")
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return writeExtracted(p, r, limit)
+	}
+	return fmt.Errorf("no .mmdb file in the archive")
+}
+
+// writeExtracted replaces the file at path with the content of r, which must
+// not exceed limit bytes.
+func writeExtracted(path string, r io.Reader, limit int64) error {
+	tmp := path + ".extract"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("more than %d bytes", limit)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
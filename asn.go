@@ -0,0 +1,163 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// defaultASNAsset is the release asset of the ASN database.
+const defaultASNAsset = "GeoLite2-ASN.mmdb"
+
+// ASNRecord is the record of the GeoLite2-ASN database.
+type ASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// asns returns the set groups of the -asn numbers in order, without
+// duplicates: AS4134 for 4134 or AS4134, giving the sets as4134_4 and
+// as4134_6.
+func (c *Config) asns() []string {
+	var groups []string
+	for _, v := range strings.Split(c.ASN, ",") {
+		v = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(v)), "AS")
+		if n, err := strconv.ParseUint(v, 10, 32); err == nil && !slices.Contains(groups, "AS"+strconv.FormatUint(n, 10)) {
+			groups = append(groups, "AS"+strconv.FormatUint(n, 10))
+		}
+	}
+	return groups
+}
+
+// asnCountries returns the -asn-country codes.
+func (c *Config) asnCountries() []string {
+	var codes []string
+	for _, cc := range strings.Split(c.ASNCountry, ",") {
+		if cc = strings.TrimSpace(cc); cc != "" {
+			codes = append(codes, cc)
+		}
+	}
+	return codes
+}
+
+// asnMMDBPath is where the ASN database is installed: -asn-mmdb-path, or
+// next to the country MMDB.
+func (c *Config) asnMMDBPath() string {
+	if c.ASNMMDBPath != "" {
+		return c.ASNMMDBPath
+	}
+	return filepath.Join(filepath.Dir(c.MMDBPath), defaultASNAsset)
+}
+
+// fetchASNDatabase downloads the ASN database from url to path and checks
+// that it is one.
+func fetchASNDatabase(ctx context.Context, cfg *Config, path, url string) error {
+	logInfo("Downloading the ASN database...")
+	err := withRetry(ctx, cfg, "ASN download", func() error {
+		return downloadFile(ctx, path, url, downloadOptions{MaxSize: int64(cfg.MaxDownloadSize), Decompress: cfg.Decompress})
+	})
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	err = extractMMDB(path, cmp.Or(int64(cfg.MaxDownloadSize), defaultMaxDownloadSize))
+	var db *maxminddb.Reader
+	if err == nil {
+		db, err = maxminddb.Open(path)
+	}
+	if err == nil {
+		if !strings.Contains(db.Metadata.DatabaseType, "ASN") {
+			err = fmt.Errorf("database type is %s", db.Metadata.DatabaseType)
+		}
+		db.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("the downloaded ASN database is invalid, keeping %s: %w", cfg.asnMMDBPath(), err)
+	}
+	return nil
+}
+
+// walkASNs passes every network of the -asn numbers in db to emit, with its
+// AS group as the country, and returns the organization of each matched AS.
+// With -asn-country only the parts located in those countries according to
+// countries, the country MMDB, are passed.
+func walkASNs(ctx context.Context, db, countries *maxminddb.Reader, cfg *Config, emit func(country, family, cidr string)) map[string]string {
+	names := map[string]string{}
+	wanted := map[string]bool{}
+	for _, g := range cfg.asns() {
+		wanted[g] = true
+	}
+	inCountry := map[string]bool{}
+	for _, cc := range cfg.asnCountries() {
+		inCountry[cc] = true
+	}
+	families := cfg.families()
+
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() && ctx.Err() == nil {
+		var rec ASNRecord
+		network, err := networks.Network(&rec)
+		if err != nil {
+			continue
+		}
+		group := "AS" + strconv.FormatUint(uint64(rec.Number), 10)
+		if !wanted[group] {
+			continue
+		}
+		if _, ok := names[group]; !ok {
+			names[group] = rec.Organization
+		}
+
+		ipNet, err := normalizeNetwork(network)
+		if err != nil {
+			continue
+		}
+		family := "ipv6"
+		if ipNet.IP.To4() != nil {
+			family = "ipv4"
+		}
+		if !slices.Contains(families, family) {
+			continue
+		}
+		if countries == nil {
+			emit(group, family, ipNet.String())
+			continue
+		}
+		for _, part := range countryParts(cfg.source(), countries, ipNet, inCountry) {
+			emit(group, family, part.String())
+		}
+	}
+	return names
+}
+
+// countryParts returns the parts of n that the country MMDB places in one of
+// the wanted countries.
+func countryParts(src geoSource, db *maxminddb.Reader, n *net.IPNet, wanted map[string]bool) []*net.IPNet {
+	var parts []*net.IPNet
+	bits, _ := n.Mask.Size()
+	networks := db.NetworksWithin(n, maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		part, rec, err := nextRecord(src, networks)
+		if err != nil || !wanted[rec.Country.ISOCode] {
+			continue
+		}
+		if part, err = normalizeNetwork(part); err != nil {
+			continue
+		}
+		// A network of the country MMDB that contains n stands for n.
+		if ones, _ := part.Mask.Size(); ones < bits {
+			part = n
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
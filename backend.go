@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// backend produces the set files for one consumer of the GeoIP data.
+type backend struct {
+	// Ext is appended to the set name to form the output file name.
+	Ext string
+	// Families lists the address families the backend writes; nil means
+	// all of them.
+	Families []string
+	// Combined backends write one file per country holding all its sets,
+	// named after the lower-case country code.
+	Combined bool
+	// File is the fixed name of a Combined backend writing the sets of all
+	// countries into one file.
+	File string
+	// Write writes sets to path: a single set, or all sets of one country
+	// for Combined backends. Nil for nft, which writeSet handles.
+	Write func(cfg *Config, path string, sets []setSpec) error
+	// Reload is run after an update that wrote the backend's files, unless
+	// -reload-cmd replaces it.
+	Reload []string
+}
+
+// backends are the accepted -backend values.
+var backends = map[string]backend{
+	"nft":      {Ext: ".nft"},
+	"nft-map":  {Combined: true, File: "geo.nft", Write: writeNftMap},
+	"ipv6calc": {Ext: ".ipv6calc", Families: []string{"ipv6"}, Write: writeIPv6calc},
+	"ipset":    {Ext: ".ipset", Write: writeIPSet},
+	"iptables": {Ext: ".iptables", Write: writeIPTables},
+	"pf":       {Ext: ".pf", Combined: true, Write: writePF},
+	"mikrotik": {Ext: ".rsc", Combined: true, Write: writeMikroTik},
+	"bird":     {Ext: ".bird", Write: writeBird, Reload: []string{"birdc", "configure"}},
+	"fw4":      {Ext: ".nft", Write: writeFW4, Reload: []string{"fw4", "reload"}},
+	"clash":    {Ext: ".clash.yaml", Combined: true, Write: writeClash},
+	"sing-box": {Ext: ".singbox.json", Combined: true, Write: writeSingbox},
+	"v2ray":    {Combined: true, File: "geoip.dat", Write: writeV2rayGeoIP},
+	"text":     {Ext: ".txt", Write: writeText},
+	"json":     {Ext: ".json", Write: writeJSON},
+	"csv":      {Ext: ".csv", Combined: true, Write: writeCSV},
+	"template": {Write: writeTemplate},
+	"bind-rpz": {Ext: ".rpz", Combined: true, Write: writeRPZ},
+	"unbound":  {Ext: ".unbound.conf", Combined: true, Write: writeUnbound, Reload: []string{"unbound-control", "reload"}},
+}
+
+func backendNames() string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// backends returns the configured -backend names.
+func (c *Config) backends() []string {
+	var names []string
+	for _, name := range strings.Split(c.Backend, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (c *Config) hasBackend(name string) bool {
+	return slices.Contains(c.backends(), name)
+}
+
+// backendPath returns the file the named backend writes for s, or "" when
+// the backend skips the family of s.
+func backendPath(cfg *Config, name string, s setSpec) string {
+	b := backends[name]
+	if b.Families != nil && !slices.Contains(b.Families, s.Family) {
+		return ""
+	}
+	switch {
+	case name == "nft":
+		return s.Path
+	case name == "nft-map":
+		return nftMapPath(cfg)
+	case name == "fw4":
+		return fw4SetPath(cfg, s)
+	case name == "template":
+		return filepath.Join(cfg.OutDir, s.Name+cfg.templateExt())
+	case b.File != "":
+		return filepath.Join(cfg.OutDir, b.File)
+	case b.Combined:
+		return filepath.Join(cfg.OutDir, strings.ToLower(s.Country)+b.Ext)
+	}
+	return filepath.Join(cfg.OutDir, s.Name+b.Ext)
+}
+
+// writeOutputs writes s with every configured per-set backend and returns
+// the set files it wrote.
+func writeOutputs(cfg *Config, s setSpec, tag string) ([]string, error) {
+	var files []string
+	for _, name := range cfg.backends() {
+		path := backendPath(cfg, name, s)
+		if path == "" || backends[name].Combined {
+			continue
+		}
+		var err error
+		if name == "nft" {
+			err = writeSet(cfg, s, tag)
+		} else {
+			err = backends[name].Write(cfg, path, []setSpec{s})
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// writeCombinedOutputs writes the files of the Combined backends, one per
+// country or a single one for backends with a File, and returns
+// "path (details)" for each.
+func writeCombinedOutputs(cfg *Config, sets []setSpec) ([]string, error) {
+	var lines []string
+	for _, name := range cfg.backends() {
+		b := backends[name]
+		if !b.Combined {
+			continue
+		}
+		var countries []string
+		byCountry := map[string][]setSpec{}
+		for _, s := range sets {
+			if backendPath(cfg, name, s) == "" {
+				continue
+			}
+			key := s.Country
+			if b.File != "" {
+				key = ""
+			}
+			if _, ok := byCountry[key]; !ok {
+				countries = append(countries, key)
+			}
+			byCountry[key] = append(byCountry[key], s)
+		}
+		for _, country := range countries {
+			group := byCountry[country]
+			path := backendPath(cfg, name, group[0])
+			if err := b.Write(cfg, path, group); err != nil {
+				return nil, err
+			}
+			n := 0
+			for _, s := range group {
+				n += len(s.Elements)
+			}
+			lines = append(lines, fmt.Sprintf("%s (%d ranges)", path, n))
+		}
+	}
+	return lines, nil
+}
+
+// writeLines creates path and writes the lines produced by fn to it.
+func writeLines(path string, fn func(w *bufio.Writer) error) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = fn(w)
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// elementPrefixes parses the elements of s.
+func elementPrefixes(s setSpec) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.Elements))
+	for _, item := range s.Elements {
+		p, err := parsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("set %s: %w", s.Name, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// writeIPv6calc writes one prefix per line as accepted by
+// `ipv6calc --in ipv6addr`, expanded with -ipv6-expand.
+func writeIPv6calc(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		for _, s := range sets {
+			prefixes, err := elementPrefixes(s)
+			if err != nil {
+				return err
+			}
+			for _, p := range prefixes {
+				if !p.Addr().Is6() || p.Addr().Is4In6() {
+					continue
+				}
+				if cfg.IPv6Expand {
+					fmt.Fprintf(w, "%s/%d\n", expandIPv6(p.Addr()), p.Bits())
+				} else {
+					fmt.Fprintln(w, p)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// expandIPv6 formats addr as eight groups of four hex digits.
+func expandIPv6(addr netip.Addr) string {
+	b := addr.As16()
+	groups := make([]string, 8)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%02x%02x", b[2*i], b[2*i+1])
+	}
+	return strings.Join(groups, ":")
+}
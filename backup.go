@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// backupManifestName is the file in every backup directory listing where
+// its files came from.
+const backupManifestName = "manifest.json"
+
+// backupTimeFormat names the backup directories; they sort by creation time.
+const backupTimeFormat = "20060102T150405.000Z"
+
+// backupManifest describes one backup: the files installed before an update
+// and the release tag they came from.
+type backupManifest struct {
+	Created time.Time    `json:"created"`
+	Tag     string       `json:"tag,omitempty"`
+	Files   []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	// Path is where the file was installed.
+	Path string `json:"path"`
+	// Name is the copy in the backup directory.
+	Name string `json:"name"`
+}
+
+// backupPath returns -backup-dir, defaulting to a directory next to the
+// state file.
+func (c *Config) backupPath() string {
+	if c.BackupDir != "" {
+		return c.BackupDir
+	}
+	return filepath.Join(filepath.Dir(c.StateFile), "backups")
+}
+
+// backupInstalled copies the installed MMDB, the files generated from it and
+// the state file into a new directory of -backup-dir before an update replaces
+// them, then prunes all but the -backups most recent backups. tag is the
+// release the files came from. Files that don't exist yet are skipped.
+func backupInstalled(cfg *Config, tag string) error {
+	now := time.Now().UTC()
+	dir := filepath.Join(cfg.backupPath(), now.Format(backupTimeFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	m := backupManifest{Created: now, Tag: tag}
+	paths := append([]string{cfg.MMDBPath}, outputFiles(cfg)...)
+	for i, path := range append(paths, cfg.StateFile) {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		// Generated files of different directories may share a name.
+		name := fmt.Sprintf("%d-%s", i, filepath.Base(path))
+		if err := installFile(path, filepath.Join(dir, name)); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		m.Files = append(m.Files, backupFile{Path: path, Name: name})
+	}
+	if len(m.Files) == 0 {
+		return os.Remove(dir)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, backupManifestName), append(data, '\n')); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	logInfo(fmt.Sprintf("Backed up %d files to %s", len(m.Files), dir))
+	return pruneBackups(cfg)
+}
+
+// listBackups returns the backup directories of -backup-dir, oldest first.
+// Directories without a manifest are not backups and are left alone.
+func listBackups(cfg *Config) ([]string, error) {
+	entries, err := os.ReadDir(cfg.backupPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range entries {
+		dir := filepath.Join(cfg.backupPath(), e.Name())
+		if _, err := os.Stat(filepath.Join(dir, backupManifestName)); e.IsDir() && err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	slices.Sort(dirs)
+	return dirs, nil
+}
+
+// pruneBackups removes all but the -backups most recent backups.
+func pruneBackups(cfg *Config) error {
+	dirs, err := listBackups(cfg)
+	if err != nil {
+		return err
+	}
+	for len(dirs) > cfg.Backups {
+		logDebug("Removing old backup " + dirs[0])
+		if err := os.RemoveAll(dirs[0]); err != nil {
+			return err
+		}
+		dirs = dirs[1:]
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// The binary set format is a 4-byte magic ("AUM4" or "AUM6", which also
+// encodes the address family), a big-endian uint32 element count and then
+// one network/mask pair per element, 4 or 16 bytes each.
+const (
+	binMagic4 = "AUM4"
+	binMagic6 = "AUM6"
+)
+
+// binaryPath returns the location of the binary copy of a set file.
+func binaryPath(setPath string) string {
+	return strings.TrimSuffix(setPath, ".nft") + ".bin"
+}
+
+func writeBinarySet(path, addrType string, items []string) error {
+	magic, size := binMagic4, 4
+	if addrType == "ipv6_addr" {
+		magic, size = binMagic6, 16
+	}
+
+	return writeLines(path, func(w *bufio.Writer) error {
+		w.WriteString(magic)
+		binary.Write(w, binary.BigEndian, uint32(len(items)))
+
+		for _, item := range items {
+			p, err := parsePrefix(item)
+			if err != nil {
+				return err
+			}
+			if p.Addr().BitLen() != size*8 {
+				return fmt.Errorf("%s does not belong in a %s set", item, addrType)
+			}
+			w.Write(p.Addr().AsSlice())
+			w.Write(prefixMask(p.Bits(), size))
+		}
+		return nil
+	})
+}
+
+// readBinarySet returns the elements of a binary set file as CIDR strings.
+func readBinarySet(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var size int
+	switch string(magic) {
+	case binMagic4:
+		size = 4
+	case binMagic6:
+		size = 16
+	default:
+		return nil, fmt.Errorf("%s: not a binary set file", path)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The header is untrusted, don't allocate more than the file can hold.
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if room := (fi.Size() - 8) / int64(2*size); int64(count) > room {
+		return nil, fmt.Errorf("%s: header lists %d elements, the file holds %d", path, count, room)
+	}
+	items := make([]string, 0, count)
+	buf := make([]byte, 2*size)
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("%s: truncated at element %d", path, i)
+		}
+		addr, _ := netip.AddrFromSlice(buf[:size])
+		bits := 0
+		for _, b := range buf[size:] {
+			for ; b&0x80 != 0; b <<= 1 {
+				bits++
+			}
+		}
+		items = append(items, netip.PrefixFrom(addr, bits).String())
+	}
+	return items, nil
+}
+
+// prefixMask returns the network mask for a prefix length as size bytes.
+func prefixMask(bits, size int) []byte {
+	mask := make([]byte, size)
+	for bit := 0; bit < bits; bit++ {
+		mask[bit/8] |= 0x80 >> (bit % 8)
+	}
+	return mask
+}
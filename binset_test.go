@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBinarySetRoundTrip(t *testing.T) {
+	tests := []struct {
+		addrType string
+		items    []string
+	}{
+		{"ipv4_addr", []string{"0.0.0.0/0", "1.0.1.0/24", "203.0.113.7/32"}},
+		{"ipv6_addr", []string{"::/0", "2001:db8::/32", "2001:db8::1/128"}},
+		{"ipv4_addr", nil},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "set.bin")
+		if err := writeBinarySet(path, tt.addrType, tt.items); err != nil {
+			t.Fatalf("writeBinarySet(%v): %v", tt.items, err)
+		}
+		got, err := readBinarySet(path)
+		if err != nil {
+			t.Fatalf("readBinarySet: %v", err)
+		}
+		if !slices.Equal(got, tt.items) {
+			t.Errorf("round trip of %v = %v", tt.items, got)
+		}
+	}
+}
+
+func TestWriteBinarySetWrongFamily(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "set.bin")
+	if err := writeBinarySet(path, "ipv4_addr", []string{"2001:db8::/32"}); err == nil {
+		t.Error("an IPv6 prefix was accepted in an IPv4 set")
+	}
+}
+
+func TestReadBinarySetInvalid(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.bin")
+	if err := writeBinarySet(valid, "ipv4_addr", []string{"1.0.1.0/24", "1.0.2.0/23"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"wrong magic", append([]byte("XYZ4"), data[4:]...), "not a binary set file"},
+		{"short magic", []byte("AU"), "EOF"},
+		{"no count", []byte(binMagic4), "EOF"},
+		{"count larger than the file", append(append([]byte(binMagic4), 0xff, 0xff, 0xff, 0xff), data[8:]...), "header lists 4294967295 elements, the file holds 2"},
+		{"truncated", data[:len(data)-3], "header lists 2 elements, the file holds 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "set.bin")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := readBinarySet(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readBinarySet = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
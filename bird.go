@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// writeBird writes a BIRD static route for every prefix of s, via
+// -bird-via-ipv4/-bird-via-ipv6 or as a blackhole when no next hop is given
+// for the family. The file is meant to be included in a protocol static
+// block of the matching channel.
+func writeBird(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		for _, s := range sets {
+			target := "blackhole"
+			if via := cfg.birdVia(s.Family); via != "" {
+				target = "via " + via
+			}
+			for _, item := range s.Elements {
+				fmt.Fprintf(w, "route %s %s;\n", item, target)
+			}
+		}
+		return nil
+	})
+}
+
+// birdVia returns the next hop of the BIRD routes for family.
+func (c *Config) birdVia(family string) string {
+	if family == "ipv6" {
+		return c.BirdViaIPv6
+	}
+	return c.BirdViaIPv4
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// canary is an address that must end up in the set of its country.
+type canary struct {
+	Addr    netip.Addr
+	Country string
+}
+
+// parseCanary parses "IP" or "IP:CC"; IPv6 addresses with a country are
+// written as "[IP]:CC". Without a country, defaultCountry is expected.
+func parseCanary(s, defaultCountry string) (canary, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return canary{Addr: addr.Unmap(), Country: defaultCountry}, nil
+	}
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return canary{}, fmt.Errorf("invalid canary %q", s)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(s[:i], "["), "]")
+	cc := strings.ToUpper(s[i+1:])
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isCountryCode(cc) {
+		return canary{}, fmt.Errorf("invalid canary %q, want IP or IP:CC", s)
+	}
+	return canary{Addr: addr.Unmap(), Country: cc}, nil
+}
+
+// checkCanaries verifies that every canary address is covered by a set of its
+// country. Misses are logged; the returned error lists them.
+func checkCanaries(canaries []canary, sets []setSpec) error {
+	var missed []string
+	for _, c := range canaries {
+		family := "ipv4"
+		if c.Addr.Is6() {
+			family = "ipv6"
+		}
+
+		checked, found := false, false
+		for _, s := range sets {
+			if s.Country != c.Country || s.Family != family {
+				continue
+			}
+			checked = true
+			if containsAddr(s.Elements, c.Addr) {
+				found = true
+				logInfo(fmt.Sprintf("Canary %s found in set %s", c.Addr, s.Name))
+				break
+			}
+		}
+		switch {
+		case !checked:
+			logWarn(fmt.Sprintf("Canary %s: no %s set is generated for %s, skipped", c.Addr, familyLabel(family), c.Country))
+		case !found:
+			logWarn(fmt.Sprintf("Canary %s is not in the %s set of %s, the MMDB may have reassigned it", c.Addr, familyLabel(family), c.Country))
+			missed = append(missed, c.Addr.String())
+		}
+	}
+	if len(missed) > 0 {
+		return fmt.Errorf("canary addresses missing from their sets: %s", strings.Join(missed, ", "))
+	}
+	return nil
+}
+
+// containsAddr reports whether any prefix in elems covers addr.
+func containsAddr(elems []string, addr netip.Addr) bool {
+	for _, e := range elems {
+		if p, err := parsePrefix(e); err == nil && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// discordMaxContent is the longest message Discord accepts.
+const discordMaxContent = 2000
+
+// sendSlack posts text to a Slack incoming webhook.
+func sendSlack(webhook, text string) error {
+	return postJSON(webhook, map[string]string{"text": text})
+}
+
+// sendDiscord posts text to a Discord webhook, truncated to the length
+// Discord accepts.
+func sendDiscord(webhook, text string) error {
+	if len(text) > discordMaxContent {
+		text = text[:discordMaxContent-3] + "..."
+	}
+	return postJSON(webhook, map[string]string{"content": text})
+}
+
+// postJSON posts v as JSON to endpoint and fails unless the response status
+// is 2xx. Webhook URLs embed their secret, so errors don't quote them.
+func postJSON(endpoint string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// isWebhookURL reports whether v is an absolute http(s) URL.
+func isWebhookURL(v string) bool {
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && !strings.ContainsAny(v, " \t")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxSidecarSize caps the download of a checksum or signature file.
+const maxSidecarSize = 1 << 20
+
+// checksumAssetNames are release assets listing the checksums of several
+// files, tried when there is no <asset>.sha256.
+var checksumAssetNames = []string{"sha256sums", "sha256sums.txt", "sha256sum.txt", "checksums.txt", "checksum.txt"}
+
+// checksumAsset returns the download URL of the release asset holding the
+// SHA256 checksum of asset.
+func checksumAsset(release *GitHubRelease, asset string) (string, error) {
+	for _, suffix := range []string{".sha256", ".sha256sum"} {
+		for _, a := range release.Assets {
+			if a.Name == asset+suffix {
+				return a.BrowserDownloadURL, nil
+			}
+		}
+	}
+	for _, name := range checksumAssetNames {
+		for _, a := range release.Assets {
+			if strings.EqualFold(a.Name, name) {
+				return a.BrowserDownloadURL, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("release %s has no SHA256 checksum asset for %s (use -checksum-url, or drop -verify-checksum)", release.TagName, asset)
+}
+
+// fetchChecksum downloads the checksum file at rawURL and returns the SHA256
+// of name it lists.
+func fetchChecksum(ctx context.Context, cfg *Config, rawURL, name, mmdbURL string, opts downloadOptions) (string, error) {
+	data, err := fetchSidecar(ctx, cfg, rawURL, mmdbURL, opts)
+	if err != nil {
+		return "", fmt.Errorf("download checksum %s: %w", redactURL(rawURL), err)
+	}
+	sum, err := parseChecksum(string(data), name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", redactURL(rawURL), err)
+	}
+	return sum, nil
+}
+
+// fetchSidecar downloads a small file published next to the MMDB, such as
+// a checksum or a signature, falling back to the -mirror locations. opts
+// are used as for the MMDB, but credentials and headers are only sent to the
+// host serving the MMDB.
+func fetchSidecar(ctx context.Context, cfg *Config, rawURL, mmdbURL string, opts downloadOptions) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if m, err := url.Parse(mmdbURL); err != nil || m.Host != u.Host {
+		opts.Header, opts.User, opts.Password = nil, "", ""
+	}
+	opts.MaxSize, opts.Decompress, opts.Tee, opts.Size = maxSidecarSize, false, nil, 0
+
+	tmp := cfg.TmpPath + ".sidecar"
+	urls := mirrorURLs(cfg, rawURL)
+	for i, mirror := range urls {
+		if i > 0 {
+			logWarn(fmt.Sprintf("Download from %s failed: %v, trying %s", redactURL(urls[i-1]), err, redactURL(mirror)))
+			opts.Header, opts.User, opts.Password = nil, "", ""
+		}
+		if u.Scheme == "ftp" {
+			err = downloadFTP(ctx, tmp, mirror, opts)
+		} else {
+			err = downloadFile(ctx, tmp, mirror, opts)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	return os.ReadFile(tmp)
+}
+
+// parseChecksum finds the SHA256 of name in a checksum file: sha256sum
+// output ("<hash>  <name>", also with "*<name>"), BSD style
+// "SHA256 (<name>) = <hash>", or a file holding nothing but the hash.
+func parseChecksum(data, name string) (string, error) {
+	var bare []string
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		var sum, file string
+		switch {
+		case len(fields) == 1:
+			bare = append(bare, fields[0])
+			continue
+		case len(fields) == 4 && fields[0] == "SHA256" && fields[2] == "=":
+			sum, file = fields[3], strings.TrimSuffix(strings.TrimPrefix(fields[1], "("), ")")
+		case len(fields) == 2:
+			sum, file = fields[0], strings.TrimPrefix(fields[1], "*")
+		default:
+			continue
+		}
+		if path.Base(file) == name {
+			return normalizeSHA256(sum)
+		}
+	}
+	if len(bare) == 1 {
+		return normalizeSHA256(bare[0])
+	}
+	return "", fmt.Errorf("no SHA256 checksum for %s", name)
+}
+
+func normalizeSHA256(s string) (string, error) {
+	s = strings.ToLower(s)
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA256 checksum %q", s)
+	}
+	return s, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestParseChecksum(t *testing.T) {
+	const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	const upper = "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+	const other = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		name, data, file string
+		want             string
+		wantErr          bool
+	}{
+		{name: "bare", data: sum + "\n", file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "uppercase", data: upper, file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "sha256sum", data: sum + "  GeoLite2-Country.mmdb\n", file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "binary mode", data: sum + " *GeoLite2-Country.mmdb\n", file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "path in the list", data: sum + "  dist/GeoLite2-Country.mmdb\n", file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "bsd", data: "SHA256 (GeoLite2-Country.mmdb) = " + sum + "\n", file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "picks the file", data: other + "  GeoLite2-City.mmdb\n" + sum + "  GeoLite2-Country.mmdb\n", file: "GeoLite2-Country.mmdb", want: sum},
+		{name: "file not listed", data: other + "  GeoLite2-City.mmdb\n", file: "GeoLite2-Country.mmdb", wantErr: true},
+		{name: "several bare", data: sum + "\n" + other + "\n", file: "GeoLite2-Country.mmdb", wantErr: true},
+		{name: "empty", data: "", file: "GeoLite2-Country.mmdb", wantErr: true},
+		{name: "not hex", data: "xyz  GeoLite2-Country.mmdb\n", file: "GeoLite2-Country.mmdb", wantErr: true},
+		{name: "too short", data: sum[:62], file: "GeoLite2-Country.mmdb", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChecksum(tt.data, tt.file)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseChecksum = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseChecksum: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseChecksum = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
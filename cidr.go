@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// prefixLast returns the highest address covered by p.
+func prefixLast(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		setHostBits(b[:], p.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := p.Addr().As16()
+	setHostBits(b[:], p.Bits())
+	return netip.AddrFrom16(b)
+}
+
+func setHostBits(b []byte, bits int) {
+	for i := range b {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			b[i] |= 0xff >> bits
+			bits = 0
+		default:
+			b[i] = 0xff
+		}
+	}
+}
+
+// rangeToPrefixes splits the inclusive range from-to into the minimal list of
+// prefixes covering it.
+func rangeToPrefixes(from, to netip.Addr) []netip.Prefix {
+	var out []netip.Prefix
+	for from.IsValid() && from.Compare(to) <= 0 {
+		p := netip.PrefixFrom(from, from.BitLen())
+		for bits := 0; bits <= from.BitLen(); bits++ {
+			candidate := netip.PrefixFrom(from, bits).Masked()
+			if candidate.Addr() == from && prefixLast(candidate).Compare(to) <= 0 {
+				p = candidate
+				break
+			}
+		}
+		out = append(out, p)
+		from = prefixLast(p).Next()
+	}
+	return out
+}
+
+// parsePrefix accepts a CIDR or a bare address, which is treated as a host
+// prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// aggregatePrefixes merges overlapping and adjacent prefixes of one address
+// family and returns the minimal sorted list covering the same addresses.
+func aggregatePrefixes(ps []netip.Prefix) []netip.Prefix {
+	var out []netip.Prefix
+	for _, r := range mergeRanges(ps) {
+		out = append(out, rangeToPrefixes(r.From, r.To)...)
+	}
+	return out
+}
+
+// aggregateElements returns the set elements items as aggregatePrefixes
+// merges them.
+func aggregateElements(items []string) ([]string, error) {
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		p, err := parsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	merged := aggregatePrefixes(prefixes)
+	out := make([]string, len(merged))
+	for i, p := range merged {
+		out[i] = p.String()
+	}
+	return out, nil
+}
+
+// addrRange is the inclusive address range From-To.
+type addrRange struct {
+	From, To netip.Addr
+}
+
+// mergeRanges returns the sorted, non-overlapping ranges covering ps, with
+// adjacent prefixes joined. IPv4 ranges sort before IPv6 ones.
+func mergeRanges(ps []netip.Prefix) []addrRange {
+	if len(ps) == 0 {
+		return nil
+	}
+	sorted := make([]netip.Prefix, len(ps))
+	for i, p := range ps {
+		sorted[i] = p.Masked()
+	}
+	slices.SortFunc(sorted, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+
+	var out []addrRange
+	cur := addrRange{sorted[0].Addr(), prefixLast(sorted[0])}
+	for _, p := range sorted[1:] {
+		// Extend the current range while p overlaps it or starts right after.
+		next := cur.To.Next()
+		if p.Addr().BitLen() == cur.To.BitLen() && (!next.IsValid() || p.Addr().Compare(next) <= 0) {
+			if last := prefixLast(p); last.Compare(cur.To) > 0 {
+				cur.To = last
+			}
+			continue
+		}
+		out = append(out, cur)
+		cur = addrRange{p.Addr(), prefixLast(p)}
+	}
+	return append(out, cur)
+}
+
+// normalizeNetwork returns n in canonical form: the address is masked to the
+// network address and IPv4-mapped IPv6 networks such as ::ffff:192.0.2.0/120
+// become plain IPv4 networks (192.0.2.0/24).
+func normalizeNetwork(n *net.IPNet) (*net.IPNet, error) {
+	ones, bits := n.Mask.Size()
+	if ip4 := n.IP.To4(); ip4 != nil && bits == 8*net.IPv6len && ones >= 96 {
+		n = &net.IPNet{IP: ip4, Mask: net.CIDRMask(ones-96, 8*net.IPv4len)}
+	}
+	_, ipNet, err := net.ParseCIDR(n.String())
+	return ipNet, err
+}
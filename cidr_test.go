@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNormalizeNetwork(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		bits int
+		size int
+		want string
+	}{
+		{"host bits set", "192.0.2.77", 24, 32, "192.0.2.0/24"},
+		{"ipv6 host bits set", "2001:db8::1", 32, 128, "2001:db8::/32"},
+		{"ipv4-mapped", "::ffff:198.51.100.0", 120, 128, "198.51.100.0/24"},
+		{"ipv4-mapped host bits set", "::ffff:198.51.100.9", 120, 128, "198.51.100.0/24"},
+		{"canonical ipv4", "203.0.113.0", 24, 32, "203.0.113.0/24"},
+		{"canonical ipv6", "2001:db8::", 48, 128, "2001:db8::/48"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if tt.size == 32 {
+				ip = ip.To4()
+			}
+			got, err := normalizeNetwork(&net.IPNet{IP: ip, Mask: net.CIDRMask(tt.bits, tt.size)})
+			if err != nil {
+				t.Fatalf("normalizeNetwork: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("normalizeNetwork = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
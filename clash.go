@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// writeClash writes a Clash/mihomo rule-provider file of behavior
+// "classical" with an IP-CIDR (IP-CIDR6 for IPv6) rule per prefix of sets.
+func writeClash(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		fmt.Fprintln(w, "payload:")
+		for _, s := range sets {
+			rule := "IP-CIDR"
+			if s.Family == "ipv6" {
+				rule = "IP-CIDR6"
+			}
+			for _, item := range s.Elements {
+				fmt.Fprintf(w, "  - '%s,%s'\n", rule, item)
+			}
+		}
+		return nil
+	})
+}
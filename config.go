@@ -0,0 +1,925 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime options of the tool.
+//
+// Every option can be set in the YAML config file under the flag name with
+// dashes replaced by underscores; command-line flags take precedence.
+type Config struct {
+	ConfigFile string `yaml:"-"`
+	StrictEnv  bool   `yaml:"-"`
+
+	LogLevel  string `yaml:"log_level"`
+	TraceHTTP bool   `yaml:"trace_http"`
+
+	OTelMetricsEndpoint string `yaml:"otel_metrics_endpoint"`
+	PingURL             string `yaml:"ping_url"`
+	NotifyOn            string `yaml:"notify_on"`
+	TelegramBotToken    string `yaml:"telegram_bot_token"`
+	TelegramChatID      string `yaml:"telegram_chat_id"`
+	SlackWebhookURL     string `yaml:"slack_webhook_url"`
+	DiscordWebhookURL   string `yaml:"discord_webhook_url"`
+	WebhookURL          string `yaml:"webhook_url"`
+	WebhookRetries      int    `yaml:"webhook_retries"`
+
+	Daemon         bool          `yaml:"daemon"`
+	Schedule       string        `yaml:"schedule"`
+	HTTPListen     string        `yaml:"http_listen"`
+	HTTPToken      string        `yaml:"http_token"`
+	ServeFiles     bool          `yaml:"serve_files"`
+	WatchConfig    bool          `yaml:"watch_config"`
+	ReloadDebounce time.Duration `yaml:"reload_debounce"`
+	ReloadMaxDelay time.Duration `yaml:"reload_max_delay"`
+
+	GitHubRepo   string `yaml:"github_repo"`
+	GitHubToken  string `yaml:"github_token"`
+	MMDBAsset    string `yaml:"mmdb_asset"`
+	TmpPath      string `yaml:"tmp_path"`
+	NftablesConf string `yaml:"nftables_conf"`
+
+	MMDBPath        string   `yaml:"mmdb_path"`
+	OutDir          string   `yaml:"out_dir"`
+	StateFile       string   `yaml:"state_file"`
+	LockFile        string   `yaml:"lock_file"`
+	ReloadCmd       string   `yaml:"reload_cmd"`
+	ReloadMode      string   `yaml:"reload_mode"`
+	NftTable        string   `yaml:"nft_table"`
+	RestartServices []string `yaml:"restart_services"`
+	NftCheck        bool     `yaml:"nft_check"`
+	NoReload        bool     `yaml:"no_reload"`
+	AlwaysReload    bool     `yaml:"always_reload"`
+	DryRun          bool     `yaml:"dry_run"`
+	DryRunDir       string   `yaml:"dry_run_dir"`
+
+	PostHooks     []postHook    `yaml:"post_hooks"`
+	HookTimeout   time.Duration `yaml:"hook_timeout"`
+	HookOnFailure string        `yaml:"hook_on_failure"`
+
+	LockTimeout time.Duration `yaml:"lock_timeout"`
+
+	Backups    int           `yaml:"backups"`
+	BackupDir  string        `yaml:"backup_dir"`
+	TimeoutNft time.Duration `yaml:"timeout_nft"`
+
+	MinChangeThreshold int `yaml:"min_change_threshold"`
+
+	MMDBURL       string   `yaml:"mmdb_url"`
+	MMDBURLHeader []string `yaml:"mmdb_url_header"`
+	Mirrors       []string `yaml:"mirrors"`
+	Proxy         string   `yaml:"proxy"`
+
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	RetryJitter  float64       `yaml:"retry_jitter"`
+	HTTPUser     string        `yaml:"http_user"`
+	HTTPPassword string        `yaml:"http_password"`
+
+	OfflineMMDB string `yaml:"offline_mmdb"`
+	FTPURL      string `yaml:"ftp_url"`
+	FTPUser     string `yaml:"ftp_user"`
+	FTPPassword string `yaml:"ftp_password"`
+
+	Source            string `yaml:"source"`
+	IPInfoToken       string `yaml:"ipinfo_token"`
+	MaxMindAccountID  string `yaml:"maxmind_account_id"`
+	MaxMindLicenseKey string `yaml:"maxmind_license_key"`
+	MaxMindEdition    string `yaml:"maxmind_edition"`
+
+	MaxDownloadSize  byteSize      `yaml:"max_download_size"`
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+	DownloadLimit    byteRate      `yaml:"download_limit"`
+	Force            bool          `yaml:"force"`
+	AllowDowngrade   bool          `yaml:"allow_downgrade"`
+	VerifyChecksum   bool          `yaml:"verify_checksum"`
+	ChecksumURL      string        `yaml:"checksum_url"`
+	GPGKey           string        `yaml:"gpg_key"`
+	SignatureURL     string        `yaml:"signature_url"`
+	Decompress       bool          `yaml:"decompress"`
+
+	Country             string `yaml:"country"`
+	Continent           string `yaml:"continent"`
+	Subdivision         string `yaml:"subdivision"`
+	ASN                 string `yaml:"asn"`
+	ASNCountry          string `yaml:"asn_country"`
+	ASNMMDBPath         string `yaml:"asn_mmdb_path"`
+	ASNMMDBURL          string `yaml:"asn_mmdb_url"`
+	Invert              bool   `yaml:"invert"`
+	CountryMetadataFile string `yaml:"country_metadata_file"`
+	DiffFile            string `yaml:"diff_file"`
+	Lang                string `yaml:"lang"`
+
+	SimulateCountry string `yaml:"simulate_country"`
+
+	CanaryIP          []string `yaml:"canary_ip"`
+	CanaryAbortOnMiss bool     `yaml:"canary_abort_on_miss"`
+
+	Netns string `yaml:"netns"`
+
+	Backend        string   `yaml:"backend"`
+	IPv6Expand     bool     `yaml:"ipv6_expand"`
+	RPZZone        string   `yaml:"rpz_zone"`
+	RPZNameserver  string   `yaml:"rpz_nameserver"`
+	ExpandToHosts  bool     `yaml:"expand_to_hosts"`
+	IPTablesTarget string   `yaml:"iptables_target"`
+	IPTablesRaw    bool     `yaml:"iptables_raw"`
+	PFSnippet      bool     `yaml:"pf_snippet"`
+	BirdViaIPv4    string   `yaml:"bird_via_ipv4"`
+	BirdViaIPv6    string   `yaml:"bird_via_ipv6"`
+	FW4Dir         string   `yaml:"fw4_dir"`
+	FW4Chain       string   `yaml:"fw4_chain"`
+	FW4Verdict     string   `yaml:"fw4_verdict"`
+	NftMapName     string   `yaml:"nft_map_name"`
+	NftMapValue    []string `yaml:"nft_map_value"`
+	SingboxSRS     bool     `yaml:"singbox_srs"`
+	Template       string   `yaml:"template"`
+	OutputFormat   string   `yaml:"output_format"`
+	Streaming      bool     `yaml:"streaming"`
+	Aggregate      bool     `yaml:"aggregate"`
+	Exclude        []string `yaml:"exclude"`
+	ExcludeFile    string   `yaml:"exclude_file"`
+	ExtraCIDRs     []string `yaml:"extra_cidrs"`
+	DropReserved   bool     `yaml:"drop_reserved"`
+	IPv4Only       bool     `yaml:"ipv4_only"`
+	IPv6Only       bool     `yaml:"ipv6_only"`
+	// SetOverrides is only read from the config file.
+	SetOverrides    map[string]countrySets `yaml:"set_overrides"`
+	PostProcessor   string                 `yaml:"post_processor"`
+	ReportUnchanged bool                   `yaml:"report_unchanged"`
+
+	NftTrailingComma optBool `yaml:"nft_trailing_comma"`
+	NftIndentSize    int     `yaml:"nft_indent_size"`
+	NftCompatLevel   string  `yaml:"nft_compat_level"`
+	AddUsageComment  bool    `yaml:"add_usage_comment"`
+	NftTypeof        bool    `yaml:"nft_typeof"`
+	NftHostOnly      bool    `yaml:"nft_host_only"`
+}
+
+// outputFormats are the accepted -output-format values. The nftables text
+// files are always written; binary copies are produced alongside and json
+// switches reports to machine-readable output.
+var outputFormats = map[string]bool{
+	"nft":    true,
+	"binary": true,
+	"json":   true,
+}
+
+func defaultConfig() *Config {
+	mmdbPath, outDir, stateFile := defaultPaths()
+	return &Config{
+		ConfigFile:     defaultConfigFile(),
+		LogLevel:       "info",
+		GitHubRepo:     defaultGitHubRepo,
+		MMDBAsset:      defaultMMDBAsset,
+		TmpPath:        defaultTmpMMDB,
+		NftablesConf:   defaultNftablesConf,
+		ReloadMode:     "restart",
+		NftTable:       "inet filter",
+		NftCheck:       true,
+		MMDBPath:       mmdbPath,
+		OutDir:         outDir,
+		StateFile:      stateFile,
+		Country:        "CN",
+		Lang:           "en",
+		Backend:        "nft",
+		RPZNameserver:  "localhost.",
+		IPTablesTarget: "DROP",
+		FW4Dir:         defaultFW4Dir,
+		FW4Verdict:     "drop",
+		MaxMindEdition: "GeoLite2-Country",
+		Source:         "maxmind",
+		NftMapName:     "geo",
+		OutputFormat:   "nft",
+		NotifyOn:       "always",
+		WebhookRetries: 3,
+
+		ConnectTimeout: 30 * time.Second,
+		RequestTimeout: 10 * time.Minute,
+
+		Retries:      3,
+		RetryBackoff: 2 * time.Second,
+		RetryJitter:  0.2,
+
+		ProgressInterval: 10 * time.Second,
+
+		HookTimeout:   time.Minute,
+		HookOnFailure: "continue",
+
+		ReloadDebounce: 5 * time.Second,
+		ReloadMaxDelay: 30 * time.Second,
+
+		NftIndentSize:  -1,
+		NftCompatLevel: "current",
+	}
+}
+
+// defaultConfigFile is the config file read when -config is not given.
+func defaultConfigFile() string {
+	if os.Getuid() == 0 {
+		return systemConfigFile
+	}
+	return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "auto-update-mmdb", filepath.Base(systemConfigFile))
+}
+
+// defaultPaths returns where the MMDB, the generated files and the state
+// live. Root uses the system locations; other users can't write there and
+// fall back to the XDG cache, data and state directories.
+func defaultPaths() (mmdbPath, outDir, stateFile string) {
+	if os.Getuid() == 0 {
+		return systemMMDB, systemOutDir, systemStateFile
+	}
+	return filepath.Join(xdgDir("XDG_CACHE_HOME", ".cache"), "auto-update-mmdb", filepath.Base(systemMMDB)),
+		filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share")), "auto-update-mmdb"),
+		filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state")), "auto-update-mmdb", filepath.Base(systemStateFile))
+}
+
+// xdgDir returns the directory named by env, or fallback below the home
+// directory when it is unset.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, fallback)
+}
+
+// bindCommonFlags registers the flags shared by the update run and all
+// subcommands, using the current values of c as defaults.
+func (c *Config) bindCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "YAML config `file`; missing is fine unless given explicitly")
+	fs.BoolVar(&c.StrictEnv, "strict-env", c.StrictEnv, "fail when the config file references an unset ${VARIABLE}")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log verbosity: debug, info, warn or error")
+	fs.BoolVar(&c.TraceHTTP, "trace-http", c.TraceHTTP, "log every HTTP request and response with headers (only active with -log-level debug)")
+	fs.StringVar(&c.Country, "country", c.Country, "comma-separated ISO `codes` of the countries whose networks form the sets, e.g. CN,RU,IR")
+	fs.StringVar(&c.Continent, "continent", c.Continent, "comma-separated continent `codes` (AF, AN, AS, EU, NA, OC, SA) whose networks form the sets instead of -country, e.g. EU gives eu4 and eu6")
+	fs.StringVar(&c.Subdivision, "subdivision", c.Subdivision, "comma-separated ISO 3166-2 `codes` of subdivisions that get their own sets from the GeoLite2-City database, e.g. CN-GD gives cn_gd4 and cn_gd6")
+	fs.StringVar(&c.ASN, "asn", c.ASN, "comma-separated AS `numbers` that get their own sets from the GeoLite2-ASN database, e.g. AS4134,AS4837 gives as4134_4, as4134_6, ...")
+	fs.StringVar(&c.ASNCountry, "asn-country", c.ASNCountry, "only keep the networks of the -asn sets located in these comma-separated country `codes`")
+	fs.StringVar(&c.ASNMMDBPath, "asn-mmdb-path", c.ASNMMDBPath, "where the ASN database is installed and read from (default GeoLite2-ASN.mmdb next to -mmdb-path)")
+	fs.StringVar(&c.ASNMMDBURL, "asn-mmdb-url", c.ASNMMDBURL, "download the ASN database from this `url` instead of the GeoLite2-ASN.mmdb asset of the release")
+	fs.BoolVar(&c.Invert, "invert", c.Invert, "fill the sets with every network NOT in -country instead")
+	fs.StringVar(&c.MMDBPath, "mmdb-path", c.MMDBPath, "where the MMDB is installed and read from")
+	fs.StringVar(&c.OutDir, "out-dir", c.OutDir, "directory of the generated set files")
+	fs.StringVar(&c.StateFile, "state-file", c.StateFile, "file keeping state between runs")
+}
+
+// bindFlags registers the flags of the update run on fs, using the current
+// values of c as defaults.
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	c.bindCommonFlags(fs)
+	fs.StringVar(&c.OTelMetricsEndpoint, "otel-metrics-endpoint", c.OTelMetricsEndpoint, "push OTLP metrics to this collector `url` (grpc://host:port, grpcs:// for TLS) after every update")
+	fs.StringVar(&c.PingURL, "ping-url", c.PingURL, "`url` to GET after every successful update, and to POST the error to with /fail appended after a failed one (healthchecks.io style)")
+	fs.StringVar(&c.NotifyOn, "notify-on", c.NotifyOn, "which runs are notified: always, change (MMDB changed or run failed) or failure")
+	fs.StringVar(&c.TelegramBotToken, "telegram-bot-token", c.TelegramBotToken, "send a summary of every run through this Telegram bot `token`")
+	fs.StringVar(&c.TelegramChatID, "telegram-chat-id", c.TelegramChatID, "Telegram chat `id` receiving the run summaries")
+	fs.StringVar(&c.SlackWebhookURL, "slack-webhook-url", c.SlackWebhookURL, "post a summary of every run to this Slack incoming webhook `url`")
+	fs.StringVar(&c.DiscordWebhookURL, "discord-webhook-url", c.DiscordWebhookURL, "post a summary of every run to this Discord webhook `url`")
+	fs.StringVar(&c.WebhookURL, "webhook-url", c.WebhookURL, "POST a JSON summary of every run to this `url`")
+	fs.IntVar(&c.WebhookRetries, "webhook-retries", c.WebhookRetries, "how many `times` to retry a failed -webhook-url request")
+	fs.BoolVar(&c.Daemon, "daemon", c.Daemon, "keep running and update again on every SIGHUP and -schedule run")
+	fs.StringVar(&c.Schedule, "schedule", c.Schedule, "in daemon mode, also update on this `schedule`: an interval such as 6h or a cron expression such as \"0 4 * * *\"")
+	fs.StringVar(&c.HTTPListen, "http-listen", c.HTTPListen, "in daemon mode, serve the HTTP API on this `address`, e.g. 127.0.0.1:8080")
+	fs.StringVar(&c.HTTPToken, "http-token", c.HTTPToken, "bearer `token` required by POST /update; the endpoint is disabled without one")
+	fs.BoolVar(&c.ServeFiles, "serve-files", c.ServeFiles, "serve the generated files on -http-listen, e.g. /cn4.nft")
+	fs.BoolVar(&c.WatchConfig, "watch-config", c.WatchConfig, "in daemon mode, re-read the config file on SIGHUP before updating")
+	fs.DurationVar(&c.ReloadDebounce, "reload-debounce", c.ReloadDebounce, "in daemon mode, wait this long after the last SIGHUP before updating")
+	fs.DurationVar(&c.ReloadMaxDelay, "reload-max-delay", c.ReloadMaxDelay, "in daemon mode, never delay a triggered update longer than this")
+	fs.IntVar(&c.Backups, "backups", c.Backups, "before an update replaces the MMDB, back it up with the generated files and keep this many backups (0 disables backups)")
+	fs.StringVar(&c.BackupDir, "backup-dir", c.BackupDir, "`directory` holding the -backups (default: backups next to the state file)")
+	fs.DurationVar(&c.LockTimeout, "lock-timeout", c.LockTimeout, "wait up to this long for another running update to finish instead of failing right away")
+	fs.DurationVar(&c.TimeoutNft, "timeout-nft", c.TimeoutNft, "kill the reload command after this long and load the nftables_conf ruleset with nft -f instead (0 waits forever)")
+	fs.BoolVar(&c.NoReload, "no-reload", c.NoReload, "write the files but don't reload nftables or any other backend")
+	fs.BoolVar(&c.AlwaysReload, "always-reload", c.AlwaysReload, "reload even when the generated files are identical to the installed ones")
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "download and parse the MMDB, but write everything to a staging directory, reload nothing and report what would change")
+	fs.StringVar(&c.DryRunDir, "dry-run-dir", c.DryRunDir, "staging `directory` of -dry-run (default: a new temporary directory)")
+	fs.Var(&hookFlag{hooks: &c.PostHooks}, "post-hook", "shell `command` to run after the sets are written and reloaded; repeatable")
+	fs.DurationVar(&c.HookTimeout, "hook-timeout", c.HookTimeout, "kill a -post-hook after this long (0 disables the timeout)")
+	fs.StringVar(&c.HookOnFailure, "hook-on-failure", c.HookOnFailure, "what a failing -post-hook does: continue with the next one, or abort the run")
+	fs.StringVar(&c.ReloadCmd, "reload-cmd", c.ReloadCmd, "`command` that applies the generated sets (default \"systemctl restart nftables\"; required when not running as root)")
+	fs.StringVar(&c.ReloadMode, "reload-mode", c.ReloadMode, "how nftables is reloaded: restart or reload the service, load only the generated sets with nft -f (sets), or program them over netlink without nft (netlink)")
+	fs.Var(newListFlag(&c.RestartServices), "restart-service", "systemd `unit` to restart once the sets are reloaded, e.g. a policy routing daemon reading them; repeatable")
+	fs.BoolVar(&c.NftCheck, "nft-check", c.NftCheck, "check the ruleset with nft -c -f before reloading nftables and abort when it fails")
+	fs.StringVar(&c.NftTable, "nft-table", c.NftTable, "nftables family and `table` holding the sets, for -reload-mode sets and netlink")
+	fs.StringVar(&c.MMDBURL, "mmdb-url", c.MMDBURL, "download the MMDB from this URL instead of the latest GitHub release")
+	fs.Var(newListFlag(&c.MMDBURLHeader), "mmdb-url-header", "extra `header` (\"Name: value\") sent with the -mmdb-url request; repeatable")
+	fs.StringVar(&c.HTTPUser, "http-user", c.HTTPUser, "basic auth user for the -mmdb-url request")
+	fs.StringVar(&c.HTTPPassword, "http-password", c.HTTPPassword, "basic auth password for the -mmdb-url request")
+	fs.StringVar(&c.GitHubToken, "github-token", c.GitHubToken, "GitHub API `token` used to look up the latest release (default $GITHUB_TOKEN)")
+	fs.Var(newListFlag(&c.Mirrors), "mirror", "download `location` tried in order when the MMDB download fails: a prefix such as https://ghproxy.example.com/, or a URL containing {url} or {name}; repeatable")
+	fs.StringVar(&c.Proxy, "proxy", c.Proxy, "send every download and API request through this http://, https:// or socks5:// proxy `url` (default from HTTPS_PROXY, HTTP_PROXY or ALL_PROXY)")
+	fs.DurationVar(&c.ConnectTimeout, "connect-timeout", c.ConnectTimeout, "give up connecting to a download, API or proxy server after this long (0 waits for the system timeout)")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "abort a download or API request, reading the body included, after this long (0 disables the timeout)")
+	fs.IntVar(&c.Retries, "retries", c.Retries, "retry the release lookup and downloads this many `times` after a network error or server failure")
+	fs.DurationVar(&c.RetryBackoff, "retry-backoff", c.RetryBackoff, "wait this long before the first retry, doubling the delay on every further one")
+	fs.Float64Var(&c.RetryJitter, "retry-jitter", c.RetryJitter, "vary each retry delay randomly by up to this `fraction` of it")
+	fs.StringVar(&c.OfflineMMDB, "offline-mmdb", c.OfflineMMDB, "offline mode: install the MMDB `file` delivered out of band and regenerate the sets from it, without any GitHub lookup or download; -mmdb-path itself only regenerates")
+	fs.StringVar(&c.FTPURL, "ftp-url", c.FTPURL, "download the MMDB from this ftp:// URL (passive mode) instead of the latest GitHub release")
+	fs.StringVar(&c.FTPUser, "ftp-user", c.FTPUser, "login user for -ftp-url (default: from the URL, else anonymous)")
+	fs.StringVar(&c.FTPPassword, "ftp-password", c.FTPPassword, "login password for -ftp-url")
+	fs.StringVar(&c.Source, "source", c.Source, "database provider: maxmind (the GitHub release or MaxMind itself), dbip (DB-IP Lite) or ipinfo (ipinfo free country database)")
+	fs.StringVar(&c.IPInfoToken, "ipinfo-token", c.IPInfoToken, "ipinfo access `token` for -source ipinfo")
+	fs.StringVar(&c.MaxMindAccountID, "maxmind-account-id", c.MaxMindAccountID, "MaxMind account `ID`; with -maxmind-license-key the MMDB is downloaded from MaxMind instead of the GitHub release")
+	fs.StringVar(&c.MaxMindLicenseKey, "maxmind-license-key", c.MaxMindLicenseKey, "MaxMind license `key` for -maxmind-account-id")
+	fs.StringVar(&c.MaxMindEdition, "maxmind-edition", c.MaxMindEdition, "MaxMind database `edition` to download, e.g. GeoLite2-City or GeoIP2-Country")
+	fs.BoolVar(&c.Force, "force", c.Force, "update even when the latest release tag is already installed")
+	fs.BoolVar(&c.AllowDowngrade, "allow-downgrade", c.AllowDowngrade, "install the downloaded MMDB even when its build epoch is not newer than the installed one")
+	fs.BoolVar(&c.VerifyChecksum, "verify-checksum", c.VerifyChecksum, "verify the downloaded MMDB against the SHA256 checksum published with it before installing it")
+	fs.StringVar(&c.ChecksumURL, "checksum-url", c.ChecksumURL, "with -verify-checksum, read the SHA256 checksum from this `url` instead of the release asset or <mmdb-url>.sha256")
+	fs.StringVar(&c.GPGKey, "gpg-key", c.GPGKey, "verify the detached GPG signature of the downloaded MMDB with the public keys in this `file` before installing it")
+	fs.StringVar(&c.SignatureURL, "signature-url", c.SignatureURL, "with -gpg-key, download the signature from this `url` instead of the release asset or <mmdb-url>.asc")
+	fs.Var(&c.MaxDownloadSize, "max-download-size", "reject MMDB downloads larger than this `size` (e.g. 200MB); defaults to 3x the Content-Length, at most 500MB")
+	fs.Var(&c.DownloadLimit, "download-limit", "cap the MMDB download at this `rate` (e.g. 2MB/s)")
+	fs.DurationVar(&c.ProgressInterval, "progress-interval", c.ProgressInterval, "log the MMDB download progress this often when stdout is not a terminal, where a progress bar is shown instead (0 disables both)")
+	fs.BoolVar(&c.Decompress, "decompress", c.Decompress, "gunzip the downloaded MMDB; automatic for .gz URLs and Content-Encoding: gzip responses")
+	fs.StringVar(&c.CountryMetadataFile, "country-metadata-file", c.CountryMetadataFile, "write a JSON file mapping each processed country code to its name")
+	fs.StringVar(&c.DiffFile, "diff-file", c.DiffFile, "write every element the update added to or removed from the sets to this `file`")
+	fs.StringVar(&c.Lang, "lang", c.Lang, "language of the names in -country-metadata-file (en, zh, de, ...)")
+	fs.StringVar(&c.Netns, "netns", c.Netns, "reload nftables inside this network namespace (`name` for ip netns, or a path such as /proc/<pid>/ns/net)")
+	fs.StringVar(&c.Backend, "backend", c.Backend, "comma-separated list of backends writing the sets: "+backendNames())
+	fs.BoolVar(&c.IPv6Expand, "ipv6-expand", c.IPv6Expand, "write fully expanded IPv6 addresses in the ipv6calc backend")
+	fs.StringVar(&c.RPZZone, "rpz-zone", c.RPZZone, "name of the zone written by the bind-rpz backend (default <country>.geo.rpz, e.g. cn.geo.rpz)")
+	fs.StringVar(&c.RPZNameserver, "rpz-nameserver", c.RPZNameserver, "nameserver in the SOA and NS records of the bind-rpz zone")
+	fs.BoolVar(&c.ExpandToHosts, "expand-to-hosts", c.ExpandToHosts, "write one unbound local-data-ptr entry per address instead of per network (can be huge)")
+	fs.StringVar(&c.IPTablesTarget, "iptables-target", c.IPTablesTarget, "`target` of the rules written by the iptables backend, e.g. DROP, ACCEPT or a chain name")
+	fs.BoolVar(&c.IPTablesRaw, "iptables-raw", c.IPTablesRaw, "write one -s CIDR rule per prefix in the iptables backend instead of matching the ipset")
+	fs.BoolVar(&c.PFSnippet, "pf-snippet", c.PFSnippet, "also write a pf.conf snippet declaring the table of each pf backend file")
+	fs.StringVar(&c.BirdViaIPv4, "bird-via-ipv4", c.BirdViaIPv4, "next hop `address` of the IPv4 routes written by the bird backend (default: blackhole routes)")
+	fs.StringVar(&c.BirdViaIPv6, "bird-via-ipv6", c.BirdViaIPv6, "next hop `address` of the IPv6 routes written by the bird backend (default: blackhole routes)")
+	fs.StringVar(&c.FW4Dir, "fw4-dir", c.FW4Dir, "OpenWrt nftables.d `directory` the fw4 backend writes to")
+	fs.StringVar(&c.FW4Chain, "fw4-chain", c.FW4Chain, "fw4 `chain` (e.g. input, forward) that gets a rule matching the sets; none by default")
+	fs.StringVar(&c.FW4Verdict, "fw4-verdict", c.FW4Verdict, "verdict of the -fw4-chain rules, e.g. drop, accept or \"jump my_chain\"")
+	fs.StringVar(&c.NftMapName, "nft-map-name", c.NftMapName, "`name` of the maps of the nft-map backend, which get 4 and 6 appended, e.g. geo4 and geo6")
+	fs.Var(newListFlag(&c.NftMapValue), "nft-map-value", "`CC=value` the nft-map backend maps the networks of country CC to: a mark such as 0x10 or a verdict such as drop or \"jump chain\" (default: the position of CC in -country as a mark); repeatable")
+	fs.BoolVar(&c.SingboxSRS, "singbox-srs", c.SingboxSRS, "also compile each sing-box rule-set into the binary .srs format")
+	fs.StringVar(&c.Template, "template", c.Template, "text/template `file` rendered for every set by the template backend, e.g. sets.conf.tmpl for cn4.conf")
+	fs.BoolVar(&c.Streaming, "streaming", c.Streaming, "write the set files while the MMDB is read instead of collecting all networks first")
+	fs.Var(newListFlag(&c.Exclude), "exclude", "`cidr` to leave out of every set, splitting the networks enclosing it; repeatable")
+	fs.StringVar(&c.ExcludeFile, "exclude-file", c.ExcludeFile, "`file` of CIDRs to leave out of every set like -exclude, one per line")
+	fs.BoolVar(&c.IPv4Only, "ipv4-only", c.IPv4Only, "generate and load only the IPv4 sets")
+	fs.BoolVar(&c.IPv6Only, "ipv6-only", c.IPv6Only, "generate and load only the IPv6 sets")
+	fs.BoolVar(&c.DropReserved, "drop-reserved", c.DropReserved, "leave private, link-local, multicast and other reserved ranges out of every set")
+	fs.Var(newListFlag(&c.ExtraCIDRs), "extra-cidrs", "`file` or http(s) URL of CIDRs, one per line, to add to every set, or with a CC= prefix to the sets of that country; repeatable")
+	fs.BoolVar(&c.Aggregate, "aggregate", c.Aggregate, "merge adjacent and overlapping networks into the fewest prefixes covering them, e.g. two /24s into a /23")
+	fs.StringVar(&c.OutputFormat, "output-format", c.OutputFormat, "comma-separated output formats: nft, binary (compact .bin copy of each set), json (machine-readable reports)")
+	fs.BoolVar(&c.ReportUnchanged, "report-unchanged", c.ReportUnchanged, "print the tag, MMDB build date and output file statistics even when the MMDB did not change")
+	fs.StringVar(&c.PostProcessor, "post-processor", c.PostProcessor, "`command` that receives the plain prefix list on stdin and prints the content of each set file")
+	fs.Var(&c.NftTrailingComma, "nft-trailing-comma", "write a comma after the last set element (default from -nft-compat-level)")
+	fs.IntVar(&c.NftIndentSize, "nft-indent-size", c.NftIndentSize, "spaces per indentation level in set files (default from -nft-compat-level)")
+	fs.StringVar(&c.NftCompatLevel, "nft-compat-level", c.NftCompatLevel, "formatting preset for the target nftables version: "+nftCompatLevels())
+	fs.IntVar(&c.MinChangeThreshold, "min-change-threshold", c.MinChangeThreshold, "only rewrite a set (and reload) when its element count changed by more than `N` since the last write")
+	fs.Var(newListFlag(&c.CanaryIP), "canary-ip", "`IP[:CC]` that must end up in the set of country CC (default: the first -country); IPv6 with a country as [IP]:CC; repeatable")
+	fs.BoolVar(&c.CanaryAbortOnMiss, "canary-abort-on-miss", c.CanaryAbortOnMiss, "abort before writing any file when a canary address is missing")
+	fs.BoolVar(&c.NftHostOnly, "nft-host-only", c.NftHostOnly, "omit \"flags interval\" from sets whose elements are all single addresses (/32 or /128)")
+	fs.BoolVar(&c.NftTypeof, "nft-typeof", c.NftTypeof, "declare sets with \"typeof ip saddr\" instead of \"type ipv4_addr\" (requires nftables >= 0.9.5)")
+	fs.BoolVar(&c.AddUsageComment, "add-usage-comment", c.AddUsageComment, "start each set file with a comment explaining how to include and reference it")
+	fs.StringVar(&c.SimulateCountry, "simulate-country", c.SimulateCountry, "testing only: skip MMDB parsing and treat the whole address space as this country `code`")
+	bindSimulationFlags(fs)
+}
+
+// apply validates c and installs the process-wide logger, HTTP and FTP
+// settings and GitHub token.
+func (c *Config) apply() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	logLevel = logLevels[c.LogLevel]
+	httpClient = newHTTPClient(c)
+	ftpDial = newFTPDial(c)
+	requestTimeout = c.RequestTimeout
+	githubToken = c.GitHubToken
+	if githubToken == "" {
+		githubToken = os.Getenv("GITHUB_TOKEN")
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	// The set_overrides and -extra-cidrs checks below compare against the
+	// set groups, so normalize the codes first.
+	c.Country = strings.ToUpper(c.Country)
+	c.Continent = strings.ToUpper(c.Continent)
+	c.Subdivision = strings.ToUpper(c.Subdivision)
+	c.ASNCountry = strings.ToUpper(c.ASNCountry)
+	c.SimulateCountry = strings.ToUpper(c.SimulateCountry)
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("invalid log level %q", c.LogLevel)
+	}
+	if c.MinChangeThreshold < 0 {
+		return fmt.Errorf("-min-change-threshold must not be negative")
+	}
+	if c.OTelMetricsEndpoint != "" {
+		if u, err := url.Parse(c.OTelMetricsEndpoint); err != nil || (u.Scheme != "grpc" && u.Scheme != "grpcs") || u.Host == "" {
+			return fmt.Errorf("invalid -otel-metrics-endpoint %q, want grpc://host:port or grpcs://host:port", c.OTelMetricsEndpoint)
+		}
+	}
+	if c.PingURL != "" {
+		if u, err := url.Parse(c.PingURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -ping-url %q, want an http:// or https:// URL", c.PingURL)
+		}
+	}
+	if !slices.Contains(notifyModes, c.NotifyOn) {
+		return fmt.Errorf("invalid -notify-on %q (want one of %s)", c.NotifyOn, strings.Join(notifyModes, ", "))
+	}
+	for flag, v := range map[string]string{"slack-webhook-url": c.SlackWebhookURL, "discord-webhook-url": c.DiscordWebhookURL, "webhook-url": c.WebhookURL} {
+		if v != "" && !isWebhookURL(v) {
+			return fmt.Errorf("invalid -%s, want an https:// URL", flag)
+		}
+	}
+	if c.WebhookRetries < 0 {
+		return fmt.Errorf("-webhook-retries must not be negative")
+	}
+	if (c.TelegramBotToken == "") != (c.TelegramChatID == "") {
+		return fmt.Errorf("-telegram-bot-token and -telegram-chat-id must be set together")
+	}
+	if owner, name, ok := strings.Cut(c.GitHubRepo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid github_repo %q, want owner/name", c.GitHubRepo)
+	}
+	for key, v := range map[string]string{"mmdb_asset": c.MMDBAsset, "tmp_path": c.TmpPath, "nftables_conf": c.NftablesConf, "mmdb_path": c.MMDBPath, "out_dir": c.OutDir, "state_file": c.StateFile} {
+		if v == "" {
+			return fmt.Errorf("%s must not be empty", key)
+		}
+	}
+	if !slices.Contains(reloadModes, c.ReloadMode) {
+		return fmt.Errorf("invalid -reload-mode %q (want one of %s)", c.ReloadMode, strings.Join(reloadModes, ", "))
+	}
+	for _, s := range c.Exclude {
+		if _, err := parsePrefix(s); err != nil {
+			return fmt.Errorf("invalid -exclude %q, want a CIDR or an address", s)
+		}
+	}
+	if err := c.validateSetOverrides(); err != nil {
+		return err
+	}
+	if c.IPv4Only && c.IPv6Only {
+		return fmt.Errorf("-ipv4-only and -ipv6-only can't be combined")
+	}
+	for _, v := range c.ExtraCIDRs {
+		cc, source := parseExtraSource(v)
+		if source == "" {
+			return fmt.Errorf("invalid -extra-cidrs %q, want a file or URL", v)
+		}
+		if cc != "" && (c.Invert || !slices.Contains(c.countries(), cc)) {
+			return fmt.Errorf("-extra-cidrs %q is for %s, which has no set", v, cc)
+		}
+	}
+	for _, svc := range c.RestartServices {
+		if svc == "" || strings.ContainsAny(svc, " \t") {
+			return fmt.Errorf("invalid -restart-service %q, want a systemd unit name", svc)
+		}
+	}
+	if c.ReloadMode != "restart" && c.ReloadCmd != "" {
+		return fmt.Errorf("-reload-mode %s can't be combined with -reload-cmd", c.ReloadMode)
+	}
+	if family, table, ok := strings.Cut(c.NftTable, " "); !ok || family == "" || table == "" || strings.ContainsAny(table, " \t") {
+		return fmt.Errorf("invalid -nft-table %q, want a family and a table such as \"inet filter\"", c.NftTable)
+	}
+	if c.LockTimeout < 0 || c.TimeoutNft < 0 {
+		return fmt.Errorf("-lock-timeout and -timeout-nft must not be negative")
+	}
+	if c.Schedule != "" {
+		sched, err := parseSchedule(c.Schedule)
+		if err != nil {
+			return err
+		}
+		if sched.next(time.Now()).IsZero() {
+			return fmt.Errorf("schedule %q never runs", c.Schedule)
+		}
+		if !c.Daemon {
+			return fmt.Errorf("-schedule requires -daemon")
+		}
+	}
+	if c.DryRun && c.Daemon {
+		return fmt.Errorf("-dry-run does not apply to -daemon")
+	}
+	if c.DryRunDir != "" && !c.DryRun {
+		return fmt.Errorf("-dry-run-dir requires -dry-run")
+	}
+	if c.HTTPListen != "" && !c.Daemon {
+		return fmt.Errorf("-http-listen requires -daemon")
+	}
+	if c.ServeFiles && c.HTTPListen == "" {
+		return fmt.Errorf("-serve-files requires -http-listen")
+	}
+	if c.HookTimeout < 0 {
+		return fmt.Errorf("-hook-timeout must not be negative")
+	}
+	if !slices.Contains(hookFailureModes, c.HookOnFailure) {
+		return fmt.Errorf("invalid -hook-on-failure %q (want one of %s)", c.HookOnFailure, strings.Join(hookFailureModes, ", "))
+	}
+	for _, h := range c.PostHooks {
+		if strings.TrimSpace(h.Command) == "" {
+			return fmt.Errorf("post_hooks: empty command")
+		}
+		if h.Timeout < 0 {
+			return fmt.Errorf("post_hooks: negative timeout for %q", h.Command)
+		}
+		if h.OnFailure != "" && !slices.Contains(hookFailureModes, h.OnFailure) {
+			return fmt.Errorf("post_hooks: invalid on_failure %q for %q (want one of %s)", h.OnFailure, h.Command, strings.Join(hookFailureModes, ", "))
+		}
+	}
+	if c.ReloadDebounce < 0 || c.ReloadMaxDelay < c.ReloadDebounce {
+		return fmt.Errorf("-reload-max-delay (%s) must not be shorter than -reload-debounce (%s)", c.ReloadMaxDelay, c.ReloadDebounce)
+	}
+	if len(c.groups()) == 0 {
+		return fmt.Errorf("-country must name at least one country")
+	}
+	for _, v := range strings.Split(c.Subdivision, ",") {
+		if v = strings.TrimSpace(v); v != "" && !subdivisionRe.MatchString(v) {
+			return fmt.Errorf("invalid -subdivision %q, want ISO 3166-2 codes such as CN-GD", v)
+		}
+	}
+	for _, v := range strings.Split(c.ASN, ",") {
+		if v = strings.TrimSpace(v); v != "" && !asnRe.MatchString(v) {
+			return fmt.Errorf("invalid -asn %q, want AS numbers such as AS4134", v)
+		}
+	}
+	for _, cc := range c.asnCountries() {
+		if !isCountryCode(cc) {
+			return fmt.Errorf("invalid -asn-country %q, want two-letter ISO codes", cc)
+		}
+	}
+	if c.ASNCountry != "" && c.ASN == "" {
+		return fmt.Errorf("-asn-country needs -asn")
+	}
+	if c.ASN != "" && c.Invert {
+		return fmt.Errorf("-asn can't be combined with -invert")
+	}
+	if c.Subdivision != "" {
+		if c.Invert {
+			return fmt.Errorf("-subdivision can't be combined with -invert")
+		}
+		// The Country database has no subdivisions.
+		if c.Source == "maxmind" && c.MMDBURL == "" && c.FTPURL == "" && c.MaxMindLicenseKey == "" && !strings.Contains(c.MMDBAsset, "City") {
+			return fmt.Errorf("-subdivision needs the GeoLite2-City database, set mmdb_asset: GeoLite2-City.mmdb in the config file")
+		}
+	}
+	for _, cc := range c.countries() {
+		if c.Continent != "" && !slices.Contains(continentCodes, cc) {
+			return fmt.Errorf("invalid -continent %q (want one of %s)", cc, strings.Join(continentCodes, ", "))
+		}
+		if !isCountryCode(cc) {
+			return fmt.Errorf("invalid -country %q, want two-letter ISO codes", cc)
+		}
+	}
+	if c.Invert && len(c.CanaryIP) > 0 {
+		return fmt.Errorf("-canary-ip can't be combined with -invert")
+	}
+	if c.RPZZone != "" && len(c.groups()) > 1 && !c.Invert && c.hasBackend("bind-rpz") {
+		return fmt.Errorf("-rpz-zone can't be used with several countries, each one gets its own zone")
+	}
+	if c.SimulateCountry != "" && !isCountryCode(c.SimulateCountry) {
+		return fmt.Errorf("invalid -simulate-country %q, want a two-letter ISO code", c.SimulateCountry)
+	}
+	for _, v := range c.CanaryIP {
+		if _, err := parseCanary(v, c.groups()[0]); err != nil {
+			return err
+		}
+	}
+	for _, f := range strings.Split(c.OutputFormat, ",") {
+		if !outputFormats[strings.TrimSpace(f)] {
+			return fmt.Errorf("unknown output format %q", f)
+		}
+	}
+	if _, err := c.nftStyle(); err != nil {
+		return err
+	}
+	if len(c.backends()) == 0 {
+		return fmt.Errorf("-backend must name at least one backend")
+	}
+	for _, name := range c.backends() {
+		if _, ok := backends[name]; !ok {
+			return fmt.Errorf("unknown backend %q (want one of %s)", name, backendNames())
+		}
+	}
+	if c.hasBackend("template") {
+		if c.Template == "" {
+			return fmt.Errorf("the template backend needs -template")
+		}
+		if _, err := c.loadTemplate(); err != nil {
+			return fmt.Errorf("-template: %w", err)
+		}
+		// The rendered files are named after the set like the nft ones.
+		others := map[string]string{}
+		for _, s := range generatedSets(c) {
+			for _, name := range c.backends() {
+				if path := backendPath(c, name, s); name != "template" && path != "" {
+					others[path] = name
+				}
+			}
+		}
+		for _, s := range generatedSets(c) {
+			if path := backendPath(c, "template", s); others[path] != "" {
+				return fmt.Errorf("-template %s renders %s, which the %s backend writes too; name it after another extension, such as sets.conf.tmpl", c.Template, path, others[path])
+			}
+		}
+	}
+	if c.hasBackend("iptables") {
+		if c.IPTablesTarget == "" || strings.ContainsAny(c.IPTablesTarget, " \t\n") {
+			return fmt.Errorf("invalid -iptables-target %q", c.IPTablesTarget)
+		}
+		if !c.IPTablesRaw && !c.hasBackend("ipset") {
+			return fmt.Errorf("the iptables backend matches the ipsets of the ipset backend, add it to -backend or set -iptables-raw")
+		}
+	}
+	if c.hasBackend("nft-map") {
+		if !setNameRe.MatchString(c.NftMapName + "4") {
+			return fmt.Errorf("invalid -nft-map-name %q", c.NftMapName)
+		}
+		if _, _, err := c.nftMapValues(); err != nil {
+			return err
+		}
+		if c.ReloadMode == "sets" || c.ReloadMode == "netlink" {
+			return fmt.Errorf("the nft-map backend is loaded with the ruleset, it can't be combined with -reload-mode %s", c.ReloadMode)
+		}
+		// A map lookup needs one value per address, so the prefixes of the
+		// groups must not overlap: country sets partition the address space,
+		// subdivision and AS sets lie within it.
+		kinds := 0
+		for _, g := range [][]string{c.countries(), c.subdivisions(), c.asns()} {
+			if len(g) > 0 {
+				kinds++
+			}
+		}
+		if kinds > 1 {
+			return fmt.Errorf("the nft-map backend can't mix -country or -continent with -subdivision or -asn, their prefixes overlap")
+		}
+		for _, v := range c.ExtraCIDRs {
+			if cc, _ := parseExtraSource(v); cc == "" && len(c.groups()) > 1 && !c.Invert {
+				return fmt.Errorf("the nft-map backend needs a CC= prefix on -extra-cidrs %q, added to every set its prefixes would overlap", v)
+			}
+		}
+	}
+	if c.FW4Chain != "" && (strings.ContainsAny(c.FW4Chain, "/. \t") || strings.TrimSpace(c.FW4Verdict) == "") {
+		return fmt.Errorf("invalid -fw4-chain %q or -fw4-verdict %q", c.FW4Chain, c.FW4Verdict)
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if via := c.birdVia(family); via != "" {
+			if addr, err := netip.ParseAddr(via); err != nil || addr.Is6() != (family == "ipv6") {
+				return fmt.Errorf("invalid -bird-via-%s %q, want an %s address", family, via, familyLabel(family))
+			}
+		}
+	}
+	if c.Streaming && !slices.Equal(c.backends(), []string{"nft"}) {
+		return fmt.Errorf("-streaming only supports the nft backend")
+	}
+	if c.Streaming {
+		// These need the complete element list before the first write.
+		switch {
+		case c.PostProcessor != "", c.hasOutputFormat("binary"), len(c.CanaryIP) > 0, c.MinChangeThreshold > 0, c.NftHostOnly, c.Aggregate, len(c.ExtraCIDRs) > 0:
+			return fmt.Errorf("-streaming can't be combined with -post-processor, -output-format binary, -canary-ip, -min-change-threshold, -nft-host-only, -aggregate or -extra-cidrs")
+		}
+	}
+	for _, h := range c.MMDBURLHeader {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid -mmdb-url-header %q, want \"Name: value\"", h)
+		}
+	}
+	if c.MMDBURL == "" && (len(c.MMDBURLHeader) > 0 || c.HTTPUser != "" || c.HTTPPassword != "") {
+		return fmt.Errorf("-mmdb-url-header, -http-user and -http-password require -mmdb-url")
+	}
+	for _, m := range c.Mirrors {
+		u, err := url.Parse(strings.NewReplacer("{url}", "", "{name}", "").Replace(m))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -mirror %q, want an http(s):// URL prefix or template", m)
+		}
+	}
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil || !proxySchemes[u.Scheme] || u.Host == "" {
+			return fmt.Errorf("invalid -proxy %q, want http://, https://, socks5:// or socks5h://host:port", c.Proxy)
+		}
+		if c.FTPURL != "" && u.Scheme != "socks5" && u.Scheme != "socks5h" {
+			return fmt.Errorf("-ftp-url needs a socks5 -proxy, HTTP proxies can't carry FTP")
+		}
+	}
+	if c.ConnectTimeout < 0 || c.RequestTimeout < 0 {
+		return fmt.Errorf("-connect-timeout and -request-timeout must not be negative")
+	}
+	if c.Backups < 0 {
+		return fmt.Errorf("-backups must not be negative")
+	}
+	if c.Retries < 0 || c.RetryBackoff < 0 {
+		return fmt.Errorf("-retries and -retry-backoff must not be negative")
+	}
+	if c.ProgressInterval < 0 {
+		return fmt.Errorf("-progress-interval must not be negative")
+	}
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		return fmt.Errorf("-retry-jitter must be between 0 and 1")
+	}
+	if c.OfflineMMDB != "" {
+		switch {
+		case c.MMDBURL != "", c.FTPURL != "", c.MaxMindLicenseKey != "", c.ASNMMDBURL != "", len(c.Mirrors) > 0:
+			return fmt.Errorf("-offline-mmdb can't be combined with -mmdb-url, -ftp-url, -maxmind-license-key, -asn-mmdb-url or -mirror, it downloads nothing")
+		case c.VerifyChecksum, c.GPGKey != "":
+			return fmt.Errorf("-offline-mmdb can't be combined with -verify-checksum or -gpg-key, there is no published checksum or signature to check")
+		}
+	}
+	if len(c.Mirrors) > 0 && c.FTPURL != "" {
+		return fmt.Errorf("-mirror does not apply to -ftp-url")
+	}
+	if c.FTPURL != "" {
+		if c.MMDBURL != "" {
+			return fmt.Errorf("-ftp-url and -mmdb-url are mutually exclusive")
+		}
+		if u, err := url.Parse(c.FTPURL); err != nil || u.Scheme != "ftp" || u.Host == "" {
+			return fmt.Errorf("invalid -ftp-url %q, want ftp://host/path", c.FTPURL)
+		}
+	} else if c.FTPUser != "" || c.FTPPassword != "" {
+		return fmt.Errorf("-ftp-user and -ftp-password require -ftp-url")
+	}
+	if _, ok := sources[c.Source]; !ok {
+		return fmt.Errorf("unknown -source %q (want one of %s)", c.Source, sourceNames())
+	}
+	if c.Source != "maxmind" && (c.MaxMindLicenseKey != "" || c.Subdivision != "") {
+		return fmt.Errorf("-maxmind-license-key and -subdivision need -source maxmind")
+	}
+	if c.Source == "ipinfo" && c.IPInfoToken == "" && c.MMDBURL == "" && c.FTPURL == "" && c.OfflineMMDB == "" {
+		return fmt.Errorf("-source ipinfo needs -ipinfo-token")
+	}
+	if c.MaxMindLicenseKey != "" || c.MaxMindAccountID != "" {
+		if c.MaxMindLicenseKey == "" || c.MaxMindAccountID == "" {
+			return fmt.Errorf("-maxmind-account-id and -maxmind-license-key must be given together")
+		}
+		if c.MMDBURL != "" || c.FTPURL != "" {
+			return fmt.Errorf("-maxmind-license-key can't be combined with -mmdb-url or -ftp-url")
+		}
+		if !maxmindEditionRe.MatchString(c.MaxMindEdition) {
+			return fmt.Errorf("invalid -maxmind-edition %q", c.MaxMindEdition)
+		}
+	}
+	if c.ChecksumURL != "" {
+		if !c.VerifyChecksum {
+			return fmt.Errorf("-checksum-url requires -verify-checksum")
+		}
+		if u, err := url.Parse(c.ChecksumURL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp") || u.Host == "" {
+			return fmt.Errorf("invalid -checksum-url %q, want an http(s):// or ftp:// URL", c.ChecksumURL)
+		}
+	} else if c.VerifyChecksum && c.FTPURL != "" {
+		return fmt.Errorf("-verify-checksum with -ftp-url requires -checksum-url")
+	}
+	if c.GPGKey != "" {
+		if _, err := c.loadKeyRing(); err != nil {
+			return err
+		}
+		if c.FTPURL != "" && c.SignatureURL == "" {
+			return fmt.Errorf("-gpg-key with -ftp-url requires -signature-url")
+		}
+	}
+	if c.SignatureURL != "" {
+		if c.GPGKey == "" {
+			return fmt.Errorf("-signature-url requires -gpg-key")
+		}
+		if u, err := url.Parse(c.SignatureURL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp") || u.Host == "" {
+			return fmt.Errorf("invalid -signature-url %q, want an http(s):// or ftp:// URL", c.SignatureURL)
+		}
+	}
+	return nil
+}
+
+// continentCodes are the continent codes of the MMDB country records.
+var continentCodes = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+
+// countries returns the -country codes in order, without duplicates. With
+// -continent the continent codes take their place, so every continent gets
+// its sets like a country would.
+func (c *Config) countries() []string {
+	list := c.Country
+	if c.Continent != "" {
+		list = c.Continent
+	}
+	var codes []string
+	for _, cc := range strings.Split(list, ",") {
+		if cc = strings.TrimSpace(cc); cc != "" && !slices.Contains(codes, cc) {
+			codes = append(codes, cc)
+		}
+	}
+	return codes
+}
+
+// subdivisionRe matches an ISO 3166-2 subdivision code such as CN-GD.
+var subdivisionRe = regexp.MustCompile(`^[A-Z]{2}-[A-Z0-9]{1,3}$`)
+
+// subdivisions returns the set groups of the -subdivision codes in order,
+// without duplicates, see subdivisionGroup.
+func (c *Config) subdivisions() []string {
+	var groups []string
+	for _, v := range strings.Split(c.Subdivision, ",") {
+		cc, sub, _ := strings.Cut(strings.TrimSpace(v), "-")
+		if g := subdivisionGroup(cc, sub); sub != "" && !slices.Contains(groups, g) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// asnRe matches an -asn number, with or without the AS prefix.
+var asnRe = regexp.MustCompile(`^(?i:as)?[0-9]{1,10}$`)
+
+// groups returns the set groups, the countries followed by the
+// subdivisions and the AS numbers.
+func (c *Config) groups() []string {
+	return slices.Concat(c.countries(), c.subdivisions(), c.asns())
+}
+
+// canaries returns the parsed -canary-ip values.
+func (c *Config) canaries() []canary {
+	var out []canary
+	for _, v := range c.CanaryIP {
+		if ca, err := parseCanary(v, c.groups()[0]); err == nil {
+			out = append(out, ca)
+		}
+	}
+	return out
+}
+
+func (c *Config) hasOutputFormat(name string) bool {
+	for _, f := range strings.Split(c.OutputFormat, ",") {
+		if strings.TrimSpace(f) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mmdbHeader returns the parsed -mmdb-url-header values.
+func (c *Config) mmdbHeader() http.Header {
+	h := http.Header{}
+	for _, v := range c.MMDBURLHeader {
+		name, value, _ := strings.Cut(v, ":")
+		h.Add(textproto.TrimString(name), textproto.TrimString(value))
+	}
+	return h
+}
+
+// setNameRe matches the set names nft accepts.
+var setNameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.]*$`)
+
+// validateSetOverrides normalizes the country codes of set_overrides and
+// checks that every generated set ends up with a distinct name and file.
+func (c *Config) validateSetOverrides() error {
+	if len(c.SetOverrides) == 0 {
+		return nil
+	}
+	if c.Invert {
+		return fmt.Errorf("set_overrides does not apply to -invert")
+	}
+	overrides := map[string]countrySets{}
+	for cc, o := range c.SetOverrides {
+		cc = strings.ToUpper(cc)
+		if !slices.Contains(c.groups(), cc) {
+			return fmt.Errorf("set_overrides: %s is not one of -country %s", cc, c.Country)
+		}
+		for _, s := range []setOverride{o.IPv4, o.IPv6} {
+			if s.Name != "" && !setNameRe.MatchString(s.Name) {
+				return fmt.Errorf("set_overrides: invalid set name %q for %s", s.Name, cc)
+			}
+			if strings.ContainsAny(s.Type, "\n{};") {
+				return fmt.Errorf("set_overrides: invalid type %q for %s", s.Type, cc)
+			}
+		}
+		overrides[cc] = o
+	}
+	c.SetOverrides = overrides
+
+	names, paths := map[string]bool{}, map[string]bool{}
+	for _, s := range generatedSets(c) {
+		if names[s.Name] || paths[s.Path] {
+			return fmt.Errorf("set_overrides: set %s or its file %s is used twice", s.Name, s.Path)
+		}
+		names[s.Name], paths[s.Path] = true, true
+	}
+	return nil
+}
+
+// isCountryCode reports whether s looks like an ISO 3166-1 alpha-2 code.
+func isCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const systemConfigFile = "/etc/auto-update-mmdb/config.yaml"
+
+// loadConfig builds the configuration for the command called name: built-in
+// defaults, then the config file, then the flags in args. bind registers the
+// command's flags.
+func loadConfig(name string, args []string, bind func(*Config, *flag.FlagSet)) (*Config, *flag.FlagSet, error) {
+	// A first pass only finds out which config file to read and how.
+	pre := defaultConfig()
+	preFlags := flag.NewFlagSet(name, flag.ContinueOnError)
+	preFlags.SetOutput(io.Discard)
+	bind(pre, preFlags)
+	preFlags.Parse(args)
+	explicit := false
+	preFlags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+
+	cfg := defaultConfig()
+	if err := readConfigFile(cfg, pre.ConfigFile, pre.StrictEnv); err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			logDebug("No config file at " + pre.ConfigFile)
+		} else {
+			return nil, nil, err
+		}
+	}
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	bind(cfg, flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags, nil
+}
+
+// readConfigFile decodes the YAML file at path onto cfg. ${VAR} references in
+// string values are expanded from the environment first; $$ is a literal $.
+func readConfigFile(cfg *Config, path string, strictEnv bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		return nil // empty file
+	}
+	var missing []string
+	expandNode(&root, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if strictEnv && len(missing) > 0 {
+		return fmt.Errorf("%s: undefined environment variables: %s", path, strings.Join(missing, ", "))
+	}
+
+	// Re-encode the expanded tree so unknown keys can be rejected.
+	expanded, err := yaml.Marshal(&root)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(expanded))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	logDebug("Loaded config file " + path)
+	return nil
+}
+
+// expandNode runs os.Expand over every string scalar below n.
+func expandNode(n *yaml.Node, mapping func(string) string) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" {
+		n.Value = os.Expand(n.Value, mapping)
+		return
+	}
+	for i, child := range n.Content {
+		// Leave mapping keys alone.
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		expandNode(child, mapping)
+	}
+}
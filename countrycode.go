@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// runCountryCodeFromFile looks up the country of every address in a
+// newline-delimited file and writes "<ip>\t<country_code>" lines in input
+// order.
+func runCountryCodeFromFile(args []string) error {
+	var input, output string
+	var workers int
+	cfg, _, err := loadConfig("country-code-from-file", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.StringVar(&input, "input", "-", "file with one IP address per line, - for stdin")
+		fs.StringVar(&output, "output", "-", "file to write the results to, - for stdout")
+		fs.IntVar(&workers, "workers", runtime.NumCPU(), "number of parallel lookups")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	if workers < 1 {
+		return fmt.Errorf("-workers must be at least 1")
+	}
+
+	db, err := maxminddb.Open(cfg.MMDBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	in := os.Stdin
+	if input != "-" {
+		if in, err = os.Open(input); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	out := os.Stdout
+	if output != "-" {
+		if out, err = os.Create(output); err != nil {
+			return err
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	err = lookupCountryCodes(db, cfg.source(), in, w, workers)
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if output != "-" {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// lookupCountryCodes streams addresses from r through workers parallel
+// lookups and writes the results to w in input order. At most a few lines per
+// worker are in flight, so memory stays bounded for any input size.
+func lookupCountryCodes(db *maxminddb.Reader, src geoSource, r io.Reader, w io.Writer, workers int) error {
+	type job struct {
+		ip     string
+		result chan string
+	}
+	jobs := make(chan job)
+	pending := make(chan chan string, 4*workers)
+
+	for range workers {
+		go func() {
+			for j := range jobs {
+				code := "-"
+				if rec, err := lookupRecord(src, db, net.ParseIP(j.ip)); err == nil && rec.Country.ISOCode != "" {
+					code = rec.Country.ISOCode
+				}
+				j.result <- j.ip + "\t" + code + "\n"
+			}
+		}()
+	}
+
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		scanner := bufio.NewScanner(r)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if net.ParseIP(line) == nil {
+				logWarn(fmt.Sprintf("line %d: skipping invalid IP address %q", n, line))
+				continue
+			}
+			j := job{ip: line, result: make(chan string, 1)}
+			pending <- j.result
+			jobs <- j
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	var werr error
+	for result := range pending {
+		line := <-result
+		if werr == nil {
+			_, werr = io.WriteString(w, line)
+		}
+	}
+	if err := <-scanErr; err != nil {
+		return err
+	}
+	return werr
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// runDaemon keeps the process running: it updates once at startup, on every
+// -schedule run, on HTTP requests (see startHTTPServer) and whenever SIGHUP
+// is received, until SIGINT or SIGTERM.
+// name and args are the original command line, needed to re-read the
+// configuration.
+func runDaemon(cfg *Config, name string, args []string) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	// A signal abandons a running update, the loop exits once it returned.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sig := <-stop
+		logInfo(fmt.Sprintf("Received %s, exiting", sig))
+		cancel()
+	}()
+
+	var current atomic.Pointer[Config]
+	current.Store(cfg)
+	httpTrigger := make(chan struct{}, 1)
+	if cfg.HTTPListen != "" {
+		srv, err := startHTTPServer(&current, httpTrigger)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+
+	logInfo(fmt.Sprintf("Daemon started (pid %d), send SIGHUP to trigger an update", os.Getpid()))
+	runCycle(ctx, cfg)
+
+	sched := newScheduler(cfg)
+	pending := &debouncer{wait: cfg.ReloadDebounce, maxDelay: cfg.ReloadMaxDelay}
+	for ctx.Err() == nil {
+		select {
+		case <-hup:
+			logInfo("SIGHUP received")
+			pending.trigger()
+		case <-httpTrigger:
+			runCycle(ctx, cfg)
+		case <-sched.C():
+			logInfo("Scheduled update")
+			runCycle(ctx, cfg)
+			sched.arm()
+		case <-pending.C():
+			pending.reset()
+			if cfg.WatchConfig {
+				cfg = reloadConfig(cfg, name, args)
+				current.Store(cfg)
+				pending.wait, pending.maxDelay = cfg.ReloadDebounce, cfg.ReloadMaxDelay
+				sched.stop()
+				sched = newScheduler(cfg)
+			}
+			runCycle(ctx, cfg)
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// debouncer coalesces bursts of triggers into one. It fires once no trigger
+// arrived for wait, but never later than maxDelay after the first trigger of
+// the burst.
+type debouncer struct {
+	wait, maxDelay time.Duration
+
+	timer *time.Timer
+	first time.Time
+}
+
+func (d *debouncer) trigger() {
+	now := time.Now()
+	if d.timer == nil {
+		d.first = now
+	}
+	delay := d.wait
+	if limit := d.first.Add(d.maxDelay).Sub(now); delay > limit {
+		delay = limit
+	}
+	if d.timer == nil {
+		d.timer = time.NewTimer(delay)
+		return
+	}
+	d.timer.Reset(delay)
+}
+
+// C fires when the pending burst is due. It is nil while nothing is pending.
+func (d *debouncer) C() <-chan time.Time {
+	if d.timer == nil {
+		return nil
+	}
+	return d.timer.C
+}
+
+func (d *debouncer) reset() {
+	d.timer = nil
+}
+
+// scheduler fires at the runs of -schedule. Without a schedule it never
+// fires.
+type scheduler struct {
+	sched schedule
+	timer *time.Timer
+}
+
+func newScheduler(cfg *Config) *scheduler {
+	s := &scheduler{}
+	if cfg.Schedule != "" {
+		// validate already parsed it.
+		s.sched, _ = parseSchedule(cfg.Schedule)
+		s.arm()
+	}
+	return s
+}
+
+// arm sets the timer to the next run after now.
+func (s *scheduler) arm() {
+	next := s.sched.next(time.Now())
+	if next.IsZero() {
+		logWarn("The schedule has no further runs")
+		s.timer = nil
+		return
+	}
+	logInfo("Next scheduled update at " + next.Format(time.RFC3339))
+	if s.timer == nil {
+		s.timer = time.NewTimer(time.Until(next))
+		return
+	}
+	s.timer.Reset(time.Until(next))
+}
+
+// C fires at the next run. It is nil without a schedule.
+func (s *scheduler) C() <-chan time.Time {
+	if s.timer == nil {
+		return nil
+	}
+	return s.timer.C
+}
+
+func (s *scheduler) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// runCycle runs one update and logs its outcome; the daemon keeps going.
+func runCycle(ctx context.Context, cfg *Config) {
+	start := time.Now()
+	if err := runUpdate(ctx, cfg); err != nil {
+		logErr(fmt.Errorf("update failed after %s: %w", time.Since(start).Round(time.Millisecond), err))
+		return
+	}
+	logInfo(fmt.Sprintf("Update cycle finished in %s", time.Since(start).Round(time.Millisecond)))
+}
+
+// reloadConfig re-reads the configuration. On failure the old configuration
+// stays in effect. Files generated under the old configuration that the new
+// one no longer produces are removed.
+func reloadConfig(old *Config, name string, args []string) *Config {
+	logInfo("Reloading configuration from " + old.ConfigFile)
+	cfg, _, err := loadConfig(name, args, (*Config).bindFlags)
+	if err == nil {
+		err = cfg.apply()
+	}
+	if err != nil {
+		logWarn(fmt.Sprintf("Config reload failed, keeping the current configuration: %v", err))
+		return old
+	}
+
+	keep := map[string]bool{}
+	for _, path := range outputFiles(cfg) {
+		keep[path] = true
+	}
+	for _, path := range outputFiles(old) {
+		if keep[path] {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			logInfo("Removed stale output " + path)
+		} else if !os.IsNotExist(err) {
+			logWarn(fmt.Sprintf("Could not remove stale output: %v", err))
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffLogLimit is how many added and removed elements of a set are logged;
+// -diff-file holds all of them.
+const diffLogLimit = 10
+
+// setDiff holds the elements an update added to and removed from a set.
+type setDiff struct {
+	Name           string
+	Added, Removed []string
+}
+
+// previousElements reads the elements of the nftables set files before an
+// update replaces them. Sets without a readable file are left out, their
+// changes aren't reported.
+func previousElements(cfg *Config, sets []setSpec) map[string][]string {
+	prev := map[string][]string{}
+	if !cfg.hasBackend("nft") {
+		return prev
+	}
+	for _, s := range sets {
+		if elems, err := readSetFile(s.Path); err == nil {
+			prev[s.Name] = elems
+		}
+	}
+	return prev
+}
+
+// diffSets compares the rewritten nftables files of sets with their elements
+// in prev.
+func diffSets(prev map[string][]string, sets []setSpec) []setDiff {
+	var diffs []setDiff
+	for _, s := range sets {
+		old, ok := prev[s.Name]
+		if !ok {
+			continue
+		}
+		cur, err := readSetFile(s.Path)
+		if err != nil {
+			logDebug(fmt.Sprintf("Not comparing set %s: %v", s.Name, err))
+			continue
+		}
+		d := setDiff{Name: s.Name, Added: missingFrom(old, cur), Removed: missingFrom(cur, old)}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// missingFrom returns the sorted elements of b that are not in a.
+func missingFrom(a, b []string) []string {
+	in := make(map[string]bool, len(a))
+	for _, e := range a {
+		in[e] = true
+	}
+	var out []string
+	for _, e := range b {
+		if !in[e] {
+			out = append(out, e)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// logDiffs logs how many elements each set gained and lost, and the first
+// diffLogLimit of them.
+func logDiffs(diffs []setDiff) {
+	for _, d := range diffs {
+		if len(d.Added) == 0 && len(d.Removed) == 0 {
+			logInfo(fmt.Sprintf("Set %s: no element added or removed", d.Name))
+			continue
+		}
+		logInfo(fmt.Sprintf("Set %s: %d added, %d removed", d.Name, len(d.Added), len(d.Removed)))
+		for _, part := range []struct {
+			sign  string
+			elems []string
+		}{{"+", d.Added}, {"-", d.Removed}} {
+			if len(part.elems) == 0 {
+				continue
+			}
+			shown := part.elems[:min(len(part.elems), diffLogLimit)]
+			more := ""
+			if n := len(part.elems) - len(shown); n > 0 {
+				more = fmt.Sprintf(" and %d more", n)
+			}
+			logInfo(fmt.Sprintf("  %s %s%s", part.sign, strings.Join(shown, " "), more))
+		}
+	}
+}
+
+// writeDiffFile writes every added and removed element for -diff-file: a
+// "# <set>: N added, M removed" line per set followed by "+ <element>" and
+// "- <element>" lines.
+func writeDiffFile(path, tag string, diffs []setDiff) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		if tag != "" {
+			fmt.Fprintf(w, "# release %s\n", tag)
+		}
+		for _, d := range diffs {
+			fmt.Fprintf(w, "# %s: %d added, %d removed\n", d.Name, len(d.Added), len(d.Removed))
+			for _, e := range d.Added {
+				fmt.Fprintf(w, "+ %s\n", e)
+			}
+			for _, e := range d.Removed {
+				fmt.Fprintf(w, "- %s\n", e)
+			}
+		}
+		return nil
+	})
+}
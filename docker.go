@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultImage = "missuo/auto-update-mmdb"
+
+// dockerService is the docker-compose service written by generate-docker.
+type dockerService struct {
+	Image       string   `yaml:"image"`
+	Restart     string   `yaml:"restart"`
+	NetworkMode string   `yaml:"network_mode"`
+	CapAdd      []string `yaml:"cap_add"`
+	Volumes     []string `yaml:"volumes"`
+	Environment []string `yaml:"environment,omitempty"`
+	Command     []string `yaml:"command"`
+}
+
+// runGenerateDocker prints a docker run command and a docker-compose.yml
+// snippet that run the tool in daemon mode against the host's nftables.
+func runGenerateDocker(args []string) error {
+	var image string
+	cfg, _, err := loadConfig("generate-docker", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.StringVar(&image, "image", defaultImage, "container image, tagged with the version of this binary")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	svc := dockerServiceFor(cfg, image)
+
+	fmt.Println("# docker run")
+	run := []string{"docker run -d --name auto-update-mmdb", "--restart " + svc.Restart, "--network " + svc.NetworkMode}
+	for _, c := range svc.CapAdd {
+		run = append(run, "--cap-add "+c)
+	}
+	for _, v := range svc.Volumes {
+		run = append(run, "-v "+shellQuote(v))
+	}
+	for _, e := range svc.Environment {
+		run = append(run, "-e "+e)
+	}
+	last := svc.Image
+	for _, a := range svc.Command {
+		last += " " + shellQuote(a)
+	}
+	fmt.Println(strings.Join(append(run, last), " \\\n  "))
+
+	fmt.Println()
+	fmt.Println("# docker-compose.yml")
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]any{
+		"services": map[string]dockerService{"auto-update-mmdb": svc},
+	}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// dockerServiceFor mounts every path cfg reads or writes at the same
+// location inside the container, so the config file works unchanged.
+func dockerServiceFor(cfg *Config, image string) dockerService {
+	tag := version
+	if tag == "dev" {
+		tag = "latest"
+	}
+	svc := dockerService{
+		Image:       image + ":" + tag,
+		Restart:     "unless-stopped",
+		NetworkMode: "host",
+		CapAdd:      []string{"NET_ADMIN"},
+		Command:     []string{"-daemon"},
+	}
+
+	var dirs []string
+	for _, dir := range []string{filepath.Dir(cfg.MMDBPath), cfg.OutDir, filepath.Dir(cfg.StateFile)} {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+			svc.Volumes = append(svc.Volumes, dir+":"+dir)
+		}
+	}
+	if data, err := os.ReadFile(cfg.ConfigFile); err == nil {
+		svc.Volumes = append(svc.Volumes, cfg.ConfigFile+":"+systemConfigFile+":ro")
+		svc.Environment = configEnvVars(string(data))
+	}
+	if cfg.ReloadCmd == "" && (cfg.ReloadMode == "restart" || cfg.ReloadMode == "reload") {
+		// There is no systemd in the container; load the host ruleset directly.
+		svc.Volumes = append(svc.Volumes, cfg.NftablesConf+":"+cfg.NftablesConf+":ro")
+		svc.Command = append(svc.Command, "-reload-cmd", "nft -f "+cfg.NftablesConf)
+	}
+	return svc
+}
+
+// configEnvVars lists the ${VAR} references of a config file, which the
+// container has to receive from its environment.
+func configEnvVars(data string) []string {
+	var names []string
+	os.Expand(data, func(name string) string {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		return ""
+	})
+	return names
+}
+
+// shellQuote quotes s for a POSIX shell when needed.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`*?[]#~;&|<>(){}") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
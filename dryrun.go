@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runDryRun performs an update with -dry-run: the MMDB is downloaded,
+// validated and parsed as usual, but it and every generated file land in a
+// staging directory, nothing is reloaded, no hook runs and no backup is
+// taken. The staged files are then compared with the installed ones. It
+// always runs, even when the installed release is the latest, so that
+// configuration changes can be tried out.
+func runDryRun(ctx context.Context, cfg *Config) error {
+	dir := cfg.DryRunDir
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "auto-update-mmdb-dry-run-"); err != nil {
+			return err
+		}
+	}
+	live := *cfg
+	live.DryRunDir, live.Force = dir, true
+	logInfo("Dry run, writing to " + dir)
+
+	sum := newRunSummary()
+	if err := update(ctx, &live, sum); err != nil {
+		return err
+	}
+	staged := live.staged()
+	if sum.Changed {
+		logInfo("The MMDB would be replaced with " + staged.MMDBPath + ".")
+	} else {
+		logInfo("The MMDB would be left as it is.")
+	}
+	stagedFiles := outputFiles(staged)
+	for i, path := range outputFiles(&live) {
+		logInfo(describeChange(path, stagedFiles[i]))
+	}
+	logInfo(fmt.Sprintf("Dry run finished in %s, nothing was installed or reloaded.", time.Since(sum.Start).Round(time.Millisecond)))
+	return nil
+}
+
+// staged returns the configuration an update with -dry-run writes with: every
+// installed and generated file is moved into DryRunDir, and hooks and
+// backups are off; update skips the reload itself. outputFiles of the result
+// lists the same files in the same order as for c.
+func (c *Config) staged() *Config {
+	s := *c
+	dir := c.DryRunDir
+	s.OutDir = dir
+	s.MMDBPath = filepath.Join(dir, filepath.Base(c.MMDBPath))
+	s.StateFile = filepath.Join(dir, "state.json")
+	s.FW4Dir = filepath.Join(dir, "fw4")
+	if c.CountryMetadataFile != "" {
+		s.CountryMetadataFile = filepath.Join(dir, filepath.Base(c.CountryMetadataFile))
+	}
+	if c.DiffFile != "" {
+		s.DiffFile = filepath.Join(dir, filepath.Base(c.DiffFile))
+	}
+	if len(c.SetOverrides) > 0 {
+		s.SetOverrides = map[string]countrySets{}
+		for cc, o := range c.SetOverrides {
+			for _, so := range []*setOverride{&o.IPv4, &o.IPv6} {
+				// Keep the directories of the overrides, so neither two of
+				// them nor one and a default <name>.nft collide.
+				if so.Path != "" {
+					abs, err := filepath.Abs(so.Path)
+					if err != nil {
+						abs = so.Path
+					}
+					so.Path = filepath.Join(dir, "set_overrides", abs)
+				}
+			}
+			s.SetOverrides[cc] = o
+		}
+	}
+	s.Backups, s.PostHooks = 0, nil
+	return &s
+}
+
+// describeChange tells how installing the staged file would change path.
+func describeChange(path, staged string) string {
+	if _, err := os.Stat(staged); err != nil {
+		return path + ": not written"
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return path + ": would be created"
+	}
+	if sameContent(path, staged) {
+		return path + ": unchanged"
+	}
+	added, removed, err := lineChanges(path, staged)
+	if err != nil {
+		return path + ": would change"
+	}
+	return fmt.Sprintf("%s: would change, %d lines added, %d removed", path, added, removed)
+}
+
+// lineChanges counts the lines of b missing from a and those of a missing
+// from b, ignoring their order.
+func lineChanges(a, b string) (added, removed int, err error) {
+	count := map[string]int{}
+	if err := forEachLine(a, func(l string) { count[l]++ }); err != nil {
+		return 0, 0, err
+	}
+	if err := forEachLine(b, func(l string) { count[l]-- }); err != nil {
+		return 0, 0, err
+	}
+	for _, n := range count {
+		if n < 0 {
+			added -= n
+		} else {
+			removed += n
+		}
+	}
+	return added, removed, nil
+}
+
+func forEachLine(path string, fn func(string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		fn(sc.Text())
+	}
+	return sc.Err()
+}
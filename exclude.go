@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// reservedPrefixes are the special-purpose ranges -drop-reserved excludes:
+// private, shared, loopback, link-local, documentation, benchmarking,
+// multicast and reserved space, which no country set should ever match.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("::ffff:0:0/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("3fff::/20"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fec0::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// exclusions returns the -exclude networks, those listed in -exclude-file
+// and with -drop-reserved the reservedPrefixes, merged into sorted ranges.
+func (c *Config) exclusions() ([]addrRange, error) {
+	var prefixes []netip.Prefix
+	if c.DropReserved {
+		prefixes = append(prefixes, reservedPrefixes...)
+	}
+	for _, s := range c.Exclude {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -exclude %q: %w", s, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	if c.ExcludeFile != "" {
+		ps, err := readPrefixFile(c.ExcludeFile)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, ps...)
+	}
+	return mergeRanges(prefixes), nil
+}
+
+// readPrefixFile reads one CIDR or address per line; blank lines and
+// everything after a # are ignored.
+func readPrefixFile(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prefixes []netip.Prefix
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		p, err := parsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, sc.Err()
+}
+
+// excludeRanges returns what is left of p once the addresses in excl, sorted
+// and not overlapping, are taken out: nothing when they cover p, p itself
+// when they don't touch it, and otherwise the prefixes covering the rest.
+func excludeRanges(p netip.Prefix, excl []addrRange) []netip.Prefix {
+	from, to := p.Addr(), prefixLast(p)
+	var out []netip.Prefix
+	cut := false
+	for _, r := range excl {
+		if r.From.BitLen() != from.BitLen() || r.To.Less(from) {
+			continue
+		}
+		if to.Less(r.From) {
+			break
+		}
+		cut = true
+		if from.Less(r.From) {
+			out = append(out, rangeToPrefixes(from, r.From.Prev())...)
+		}
+		if from = r.To.Next(); !from.IsValid() || to.Less(from) {
+			return out
+		}
+	}
+	if !cut {
+		return []netip.Prefix{p}
+	}
+	return append(out, rangeToPrefixes(from, to)...)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExcludeRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		exclude []string
+		want    []string
+	}{
+		{"untouched", "1.0.1.0/24", []string{"10.0.0.0/8"}, []string{"1.0.1.0/24"}},
+		{"nothing excluded", "1.0.1.0/24", nil, []string{"1.0.1.0/24"}},
+		{"covered", "10.1.0.0/16", []string{"10.0.0.0/8"}, nil},
+		{"exact", "10.0.0.0/8", []string{"10.0.0.0/8"}, nil},
+		{"first half", "1.0.0.0/23", []string{"1.0.0.0/24"}, []string{"1.0.1.0/24"}},
+		{"last half", "1.0.0.0/23", []string{"1.0.1.0/24"}, []string{"1.0.0.0/24"}},
+		{"hole", "1.0.0.0/24", []string{"1.0.0.128/32"}, []string{"1.0.0.0/25", "1.0.0.129/32", "1.0.0.130/31", "1.0.0.132/30", "1.0.0.136/29", "1.0.0.144/28", "1.0.0.160/27", "1.0.0.192/26"}},
+		{"two holes", "1.0.0.0/22", []string{"1.0.0.0/24", "1.0.2.0/24"}, []string{"1.0.1.0/24", "1.0.3.0/24"}},
+		{"overlapping the start", "1.0.1.0/24", []string{"1.0.0.0/23"}, nil},
+		{"other family", "2001:db8::/32", []string{"0.0.0.0/0"}, []string{"2001:db8::/32"}},
+		{"ipv6", "2001:db8::/32", []string{"2001:db8::/33"}, []string{"2001:db8:8000::/33"}},
+		{"up to the last address", "255.255.255.0/24", []string{"255.255.255.128/25"}, []string{"255.255.255.0/25"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var excl []netip.Prefix
+			for _, s := range tt.exclude {
+				excl = append(excl, netip.MustParsePrefix(s))
+			}
+			var got []string
+			for _, p := range excludeRanges(netip.MustParsePrefix(tt.prefix), mergeRanges(excl)) {
+				got = append(got, p.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("excludeRanges(%s) = [%s], want [%s]", tt.prefix, strings.Join(got, " "), strings.Join(tt.want, " "))
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+)
+
+// writeText writes one prefix per line.
+func writeText(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		for _, s := range sets {
+			for _, item := range s.Elements {
+				fmt.Fprintln(w, item)
+			}
+		}
+		return nil
+	})
+}
+
+// writeJSON writes the prefixes of sets as a JSON array of strings.
+func writeJSON(cfg *Config, path string, sets []setSpec) error {
+	items := []string{}
+	for _, s := range sets {
+		items = append(items, s.Elements...)
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// writeCSV writes a cidr,family row (family being ipv4 or ipv6) for every
+// prefix of sets, after a header row.
+func writeCSV(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"cidr", "family"})
+		for _, s := range sets {
+			for _, item := range s.Elements {
+				cw.Write([]string{item, s.Family})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// maxExtraSize caps the download of an -extra-cidrs list.
+const maxExtraSize = 16 << 20
+
+// extraCIDRs are the networks of one -extra-cidrs source.
+type extraCIDRs struct {
+	// Country is the set they are added to, or "" for every set.
+	Country  string
+	Source   string
+	Prefixes []netip.Prefix
+}
+
+// parseExtraSource splits an -extra-cidrs value into its optional "CC="
+// country prefix and the file or URL.
+func parseExtraSource(v string) (country, source string) {
+	if cc, rest, ok := strings.Cut(v, "="); ok && len(cc) == 2 && isCountryCode(strings.ToUpper(cc)) {
+		return strings.ToUpper(cc), rest
+	}
+	return "", v
+}
+
+// loadExtraCIDRs reads every -extra-cidrs source, downloading those given as
+// an http(s) URL.
+func loadExtraCIDRs(ctx context.Context, cfg *Config) ([]extraCIDRs, error) {
+	var extras []extraCIDRs
+	for _, v := range cfg.ExtraCIDRs {
+		country, source := parseExtraSource(v)
+		path := source
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			path = cfg.TmpPath + ".extra"
+			err := withRetry(ctx, cfg, "Downloading "+redactURL(source), func() error {
+				return downloadFile(ctx, path, source, downloadOptions{MaxSize: maxExtraSize})
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		prefixes, err := readPrefixFile(path)
+		if path != source {
+			os.Remove(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		logDebug(fmt.Sprintf("Read %d extra networks from %s", len(prefixes), redactURL(source)))
+		extras = append(extras, extraCIDRs{Country: country, Source: source, Prefixes: prefixes})
+	}
+	return extras, nil
+}
+
+// extraElements returns the extra networks for set s that survive -exclude,
+// and whether any source applies to s. With -invert the sources without a
+// country go into the inverted sets.
+func extraElements(extras []extraCIDRs, s setSpec, excl []addrRange) ([]string, bool) {
+	var out []string
+	applies := false
+	for _, e := range extras {
+		if e.Country != "" && e.Country != s.Country {
+			continue
+		}
+		applies = true
+		for _, p := range e.Prefixes {
+			if p.Addr().Is4() != (s.Family == "ipv4") {
+				continue
+			}
+			for _, r := range excludeRanges(p, excl) {
+				out = append(out, r.String())
+			}
+		}
+	}
+	return out, applies
+}
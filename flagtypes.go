@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// byteSize is a flag.Value for sizes such as "200MB" or "1.5G". Units are
+// binary, so 1MB is 1024*1024 bytes.
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+func (b *byteSize) UnmarshalYAML(value *yaml.Node) error {
+	return b.Set(value.Value)
+}
+
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(v))
+	mult := 1.0
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return int64(f * mult), nil
+}
+
+// byteRate is a flag.Value for transfer rates such as "2MB/s"; the "/s" is
+// optional.
+type byteRate int64
+
+func (r *byteRate) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(*r), 10) + "/s"
+}
+
+func (r *byteRate) Set(v string) error {
+	n, err := parseByteSize(strings.TrimSuffix(strings.TrimSpace(v), "/s"))
+	if err != nil {
+		return fmt.Errorf("invalid rate %q", v)
+	}
+	*r = byteRate(n)
+	return nil
+}
+
+func (r *byteRate) UnmarshalYAML(value *yaml.Node) error {
+	return r.Set(value.Value)
+}
+
+// optBool is a boolean flag.Value that remembers whether it was set.
+type optBool struct {
+	value, set bool
+}
+
+func (b *optBool) IsBoolFlag() bool { return true }
+
+func (b *optBool) String() string {
+	if b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optBool) UnmarshalYAML(value *yaml.Node) error {
+	return b.Set(value.Value)
+}
+
+func (b *optBool) Set(v string) error {
+	value, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	b.value, b.set = value, true
+	return nil
+}
+
+// listFlag is a flag.Value collecting every occurrence of a repeatable flag.
+// The first occurrence replaces any values loaded from the config file.
+type listFlag struct {
+	list *[]string
+	set  bool
+}
+
+func newListFlag(list *[]string) *listFlag {
+	return &listFlag{list: list}
+}
+
+func (l *listFlag) String() string {
+	if l == nil || l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	if !l.set {
+		*l.list = nil
+		l.set = true
+	}
+	*l.list = append(*l.list, v)
+	return nil
+}
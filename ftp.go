@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// downloadFTP fetches an ftp:// URL into path using passive mode. Credentials
+// in opts take precedence over those in the URL; without either the login is
+// anonymous. The download is aborted when ctx is done or after
+// -request-timeout.
+func downloadFTP(ctx context.Context, path, rawURL string, opts downloadOptions) (err error) {
+	if err := simulatedDownloadError(); err != nil {
+		return err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if opts.User != "" {
+		user = opts.User
+	}
+	if opts.Password != "" {
+		password = opts.Password
+	}
+
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+	conns := &ftpConns{ctx: ctx}
+	defer context.AfterFunc(ctx, conns.interrupt)()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("ftp download: %w", ctx.Err())
+		}
+	}()
+
+	conn, err := ftp.Dial(addr, ftp.DialWithDialFunc(conns.dial))
+	if err != nil {
+		return err
+	}
+	defer conn.Quit()
+	if err := conn.Login(user, password); err != nil {
+		return fmt.Errorf("ftp login as %s: %w", user, err)
+	}
+
+	gz := opts.Decompress || isGzipPath(u.Path)
+	total, err := conn.FileSize(u.Path)
+	if err != nil {
+		total = -1
+	}
+	size := total
+	if gz {
+		size = -1
+	}
+	limit := downloadLimit(opts.MaxSize, size)
+	if size > limit {
+		return fmt.Errorf("download too large: %d bytes announced, limit is %d", size, limit)
+	}
+
+	resp, err := conn.Retr(u.Path)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	var body io.Reader = resp
+	if opts.RateLimit > 0 {
+		body = newThrottledReader(body, opts.RateLimit)
+	}
+	var prog *progress
+	if opts.Progress > 0 {
+		prog = newProgress(0, total, opts.Progress)
+		body = io.TeeReader(body, prog)
+	}
+	err = saveBody(path, teeBody(body, opts.Tee), limit, gz)
+	if prog != nil {
+		if err != nil {
+			prog.clear()
+		} else {
+			prog.finish()
+		}
+	}
+	return err
+}
+
+// ftpConns dials the connections of one FTP download with ftpDial and
+// interrupts all of them once ctx is done, so that a stalled transfer can't
+// block forever.
+type ftpConns struct {
+	ctx   context.Context
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (c *ftpConns) dial(network, address string) (net.Conn, error) {
+	conn, err := ftpDial(c.ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.conns = append(c.conns, conn)
+	c.mu.Unlock()
+	return conn, nil
+}
+
+func (c *ftpConns) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		conn.SetDeadline(time.Now())
+	}
+}
+
+// redactURL masks the password and the token query parameter in rawURL for
+// logging.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if q := u.Query(); q.Has("token") {
+		q.Set("token", "xxxxx")
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactError applies redactURL to the URL of a request error.
+func redactError(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		uerr.URL = redactURL(uerr.URL)
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const defaultFW4Dir = "/usr/share/nftables.d"
+
+// fw4SetPath returns where the fw4 backend writes set s: the table-post
+// directory, whose files OpenWrt's firewall4 includes inside table inet fw4.
+func fw4SetPath(cfg *Config, s setSpec) string {
+	return filepath.Join(cfg.FW4Dir, "table-post", s.Name+".nft")
+}
+
+// fw4RulePath returns the rule file of set s included at the start of the
+// -fw4-chain chain, or "" when no chain is configured.
+func fw4RulePath(cfg *Config, s setSpec) string {
+	if cfg.FW4Chain == "" {
+		return ""
+	}
+	return filepath.Join(cfg.FW4Dir, "chain-pre", cfg.FW4Chain, s.Name+".nft")
+}
+
+// writeFW4 writes s as an nftables set for the fw4 table and, with
+// -fw4-chain, a rule applying -fw4-verdict to packets from its addresses.
+func writeFW4(cfg *Config, path string, sets []setSpec) error {
+	style, err := cfg.nftStyle()
+	if err != nil {
+		return err
+	}
+	for _, s := range sets {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		items, style := style.forElements(s.Elements)
+		if _, err := writeSetFile(context.Background(), path, s, sendAll(items), style); err != nil {
+			return err
+		}
+
+		rulePath := fw4RulePath(cfg, s)
+		if rulePath == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(rulePath), 0755); err != nil {
+			return err
+		}
+		match := "ip saddr"
+		if s.Family == "ipv6" {
+			match = "ip6 saddr"
+		}
+		rule := fmt.Sprintf("%s @%s %s\n", match, s.Name, cfg.FW4Verdict)
+		if err := writeFileAtomic(rulePath, []byte(rule)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
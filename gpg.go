@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// signatureSuffixes are the extensions of detached signatures looked for
+// next to the MMDB, in order.
+var signatureSuffixes = []string{".asc", ".sig", ".gpg"}
+
+// signatureAsset returns the download URL of the release asset holding the
+// detached signature of asset.
+func signatureAsset(release *GitHubRelease, asset string) (string, error) {
+	for _, suffix := range signatureSuffixes {
+		for _, a := range release.Assets {
+			if a.Name == asset+suffix {
+				return a.BrowserDownloadURL, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("release %s has no signature asset for %s (use -signature-url, or drop -gpg-key)", release.TagName, asset)
+}
+
+// loadKeyRing reads the -gpg-key public keys, ASCII-armored or binary.
+func (c *Config) loadKeyRing() (openpgp.EntityList, error) {
+	data, err := os.ReadFile(c.GPGKey)
+	if err != nil {
+		return nil, err
+	}
+	var keys openpgp.EntityList
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-gpg-key %s: %w", c.GPGKey, err)
+	}
+	return keys, nil
+}
+
+// signatureCheck verifies a detached signature over the bytes written to it
+// while the MMDB downloads, so the body is not read twice.
+type signatureCheck struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// signature is a detached signature and the keys it must verify with.
+type signature struct {
+	keys openpgp.EntityList
+	data []byte
+}
+
+// fetchSignature downloads the signature at sigURL.
+func fetchSignature(ctx context.Context, cfg *Config, sigURL, mmdbURL string, opts downloadOptions) (*signature, error) {
+	keys, err := cfg.loadKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	data, err := fetchSidecar(ctx, cfg, sigURL, mmdbURL, opts)
+	if err != nil {
+		return nil, fmt.Errorf("download signature %s: %w", redactURL(sigURL), err)
+	}
+	return &signature{keys: keys, data: data}, nil
+}
+
+// start begins checking s against the data written to the returned check.
+func (s *signature) start() *signatureCheck {
+	check := func(signed io.Reader) (*openpgp.Entity, error) {
+		if bytes.Contains(s.data, []byte("-----BEGIN PGP SIGNATURE")) {
+			return openpgp.CheckArmoredDetachedSignature(s.keys, signed, bytes.NewReader(s.data), nil)
+		}
+		return openpgp.CheckDetachedSignature(s.keys, signed, bytes.NewReader(s.data), nil)
+	}
+
+	pr, pw := io.Pipe()
+	c := &signatureCheck{pw: pw, done: make(chan error, 1)}
+	go func() {
+		signer, err := check(pr)
+		// Keep the download going when the check gave up early.
+		io.Copy(io.Discard, pr)
+		if err == nil {
+			logInfo("GPG signature verified, signed by " + signerName(signer))
+		}
+		c.done <- err
+	}()
+	return c
+}
+
+func (c *signatureCheck) Write(p []byte) (int, error) {
+	return c.pw.Write(p)
+}
+
+// finish ends the signed data and returns the result of the check. A
+// non-nil downloadErr aborts it, the result is then meaningless.
+func (c *signatureCheck) finish(downloadErr error) error {
+	c.pw.CloseWithError(downloadErr)
+	return <-c.done
+}
+
+// signerName describes the key that made a signature by its first identity
+// and key ID.
+func signerName(e *openpgp.Entity) string {
+	id := strings.ToUpper(e.PrimaryKey.KeyIdString())
+	for name := range e.Identities {
+		return name + " (" + id + ")"
+	}
+	return id
+}
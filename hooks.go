@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hookFailureModes are the accepted on_failure values of a post-update hook:
+// continue logs the failure and runs the remaining hooks, abort skips them
+// and fails the run.
+var hookFailureModes = []string{"continue", "abort"}
+
+// postHook is a shell command run once the sets are written and reloaded.
+// Timeout and OnFailure default to -hook-timeout and -hook-on-failure.
+type postHook struct {
+	Command   string        `yaml:"command"`
+	Timeout   time.Duration `yaml:"timeout"`
+	OnFailure string        `yaml:"on_failure"`
+}
+
+// UnmarshalYAML accepts a hook as a plain command string as well as a
+// mapping.
+func (h *postHook) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		h.Command = value.Value
+		return nil
+	}
+	type plain postHook
+	return value.Decode((*plain)(h))
+}
+
+// hookFlag is the repeatable -post-hook flag. Like listFlag, the first
+// occurrence replaces the hooks loaded from the config file.
+type hookFlag struct {
+	hooks *[]postHook
+	set   bool
+}
+
+func (f *hookFlag) String() string {
+	if f == nil || f.hooks == nil {
+		return ""
+	}
+	var cmds []string
+	for _, h := range *f.hooks {
+		cmds = append(cmds, h.Command)
+	}
+	return strings.Join(cmds, ",")
+}
+
+func (f *hookFlag) Set(v string) error {
+	if !f.set {
+		*f.hooks = nil
+		f.set = true
+	}
+	*f.hooks = append(*f.hooks, postHook{Command: v})
+	return nil
+}
+
+// runHooks runs the -post-hook commands in order through sh -c. They see
+// the AUM_TAG, AUM_CHANGED and AUM_OUT_DIR environment variables describing
+// the run.
+func runHooks(cfg *Config, sum *runSummary) error {
+	env := append(os.Environ(),
+		"AUM_TAG="+sum.Tag,
+		"AUM_CHANGED="+strconv.FormatBool(sum.Changed),
+		"AUM_OUT_DIR="+cfg.OutDir,
+	)
+	for _, h := range cfg.PostHooks {
+		timeout, onFailure := h.Timeout, h.OnFailure
+		if timeout == 0 {
+			timeout = cfg.HookTimeout
+		}
+		if onFailure == "" {
+			onFailure = cfg.HookOnFailure
+		}
+		logInfo("Running hook: " + h.Command)
+		err := runHook(h.Command, env, timeout)
+		if err == nil {
+			continue
+		}
+		if onFailure == "abort" {
+			return fmt.Errorf("hook %q failed: %w", h.Command, err)
+		}
+		logWarn(fmt.Sprintf("Hook %q failed: %v", h.Command, err))
+	}
+	return nil
+}
+
+// runHook runs command, killing it after timeout unless timeout is zero.
+func runHook(command string, env []string, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = env
+	// Don't wait for children that inherited the output pipe once the
+	// shell is killed.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("killed after %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(out)))
+	}
+	if s := strings.TrimSpace(string(out)); s != "" {
+		logDebug("Hook output: " + s)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// startHTTPServer serves the daemon's HTTP API on -http-listen, using the
+// configuration current holds at the time of each request:
+//
+//   - POST /update with the -http-token as bearer token queues an update on
+//     trigger; a request arriving while one is already queued is merged into
+//     it. Without a token the endpoint is disabled.
+//   - GET /metrics returns stats for Prometheus.
+//   - with -serve-files, GET /<name> returns a generated file of out_dir.
+func startHTTPServer(current *atomic.Pointer[Config], trigger chan<- struct{}) (*http.Server, error) {
+	cfg := current.Load()
+	mux := http.NewServeMux()
+	mux.Handle("POST /update", requireToken(current, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case trigger <- struct{}{}:
+			logInfo("Update requested over HTTP by " + r.RemoteAddr)
+		default:
+			logDebug("Update requested over HTTP by " + r.RemoteAddr + ", one is already queued")
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "update queued")
+	})))
+	mux.HandleFunc("GET /metrics", servePrometheus)
+	mux.HandleFunc("GET /{name...}", func(w http.ResponseWriter, r *http.Request) {
+		cfg := current.Load()
+		path, ok := servedFiles(cfg)[r.PathValue("name")]
+		if !cfg.ServeFiles || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, path)
+	})
+	if cfg.HTTPToken == "" {
+		logWarn("-http-token is not set, POST /update is disabled")
+	}
+
+	ln, err := net.Listen("tcp", cfg.HTTPListen)
+	if err != nil {
+		return nil, fmt.Errorf("-http-listen: %w", err)
+	}
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErr(fmt.Errorf("HTTP server: %w", err))
+		}
+	}()
+	logInfo("HTTP API listening on " + ln.Addr().String())
+	return srv, nil
+}
+
+// servedFiles maps the names served with -serve-files, the paths relative to
+// out_dir, to the generated files. Files outside out_dir are not served.
+func servedFiles(cfg *Config) map[string]string {
+	files := map[string]string{}
+	for _, path := range outputFiles(cfg) {
+		rel, err := filepath.Rel(cfg.OutDir, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		files[filepath.ToSlash(rel)] = path
+	}
+	return files
+}
+
+// requireToken rejects requests without "Authorization: Bearer <token>",
+// and all of them when no -http-token is configured.
+func requireToken(current *atomic.Pointer[Config], next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := current.Load().HTTPToken
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logWarn("Rejected unauthorized HTTP request from " + r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// httpClient is used for every outgoing HTTP request.
+var httpClient = http.DefaultClient
+
+// requestTimeout bounds every download and GitHub API request, reading the
+// body included, when set. It is installed by Config.apply.
+var requestTimeout time.Duration
+
+// newDialer returns the dialer of HTTP and FTP connections, which gives up
+// after -connect-timeout.
+func newDialer(cfg *Config) *net.Dialer {
+	return &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+}
+
+// sensitiveHeaders are masked when HTTP traffic is traced.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+func newHTTPClient(cfg *Config) *http.Client {
+	// Custom headers usually carry credentials, keep them out of traces.
+	for name := range cfg.mmdbHeader() {
+		sensitiveHeaders[name] = true
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = httpProxy(cfg)
+	base.DialContext = newDialer(cfg).DialContext
+	if cfg.ConnectTimeout > 0 {
+		base.TLSHandshakeTimeout = cfg.ConnectTimeout
+	}
+	var transport http.RoundTripper = base
+	if cfg.TraceHTTP {
+		if logLevel > levelDebug {
+			logWarn("-trace-http has no effect unless -log-level is debug")
+		} else {
+			transport = &tracingTransport{next: transport, secretURLs: secretURLs(cfg)}
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.RequestTimeout}
+}
+
+// secretURLs are the notification endpoints whose path is a credential: the
+// Telegram bot token and the Slack, Discord, webhook and ping URLs.
+func secretURLs(cfg *Config) []string {
+	var urls []string
+	if cfg.TelegramBotToken != "" {
+		urls = append(urls, telegramAPI+"/bot")
+	}
+	for _, u := range []string{cfg.SlackWebhookURL, cfg.DiscordWebhookURL, cfg.WebhookURL, cfg.PingURL} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// tracingTransport logs the method, URL, headers, status and timing of every
+// HTTP exchange at debug level.
+type tracingTransport struct {
+	next http.RoundTripper
+	// secretURLs are logged with their scheme and host only.
+	secretURLs []string
+}
+
+// traceURL returns the URL of req as it may be logged.
+func (t *tracingTransport) traceURL(req *http.Request) string {
+	u := req.URL.String()
+	for _, secret := range t.secretURLs {
+		if strings.HasPrefix(u, secret) {
+			return req.URL.Scheme + "://" + req.URL.Host + "/xxxxx"
+		}
+	}
+	return redactURL(u)
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	logDebug(fmt.Sprintf("HTTP > %s %s", req.Method, t.traceURL(req)))
+	for _, line := range headerLines(req.Header) {
+		logDebug("HTTP >   " + line)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		logDebug(fmt.Sprintf("HTTP < %s %s failed after %s: %v", req.Method, t.traceURL(req), elapsed, err))
+		return nil, err
+	}
+
+	logDebug(fmt.Sprintf("HTTP < %s (%s)", resp.Status, elapsed))
+	for _, line := range headerLines(resp.Header) {
+		logDebug("HTTP <   " + line)
+	}
+	return resp, nil
+}
+
+// headerLines renders h as sorted "Name: value" lines with sensitive values
+// masked.
+func headerLines(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		for _, v := range h[name] {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				v = "***"
+			}
+			lines = append(lines, name+": "+v)
+		}
+	}
+	return lines
+}
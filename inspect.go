@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/netip"
+)
+
+// runLookup reports which generated sets contain the given addresses.
+func runLookup(args []string) error {
+	var readBinary bool
+	cfg, fs, err := loadConfig("lookup", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.BoolVar(&readBinary, "read-binary", false, "read the binary (.bin) copies of the sets")
+		fs.Usage = func() {
+			fmt.Fprintln(fs.Output(), "Usage: auto-update-mmdb lookup [flags] IP...")
+			fs.PrintDefaults()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no address given")
+	}
+
+	var addrs []netip.Addr
+	for _, arg := range fs.Args() {
+		addr, err := netip.ParseAddr(arg)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+
+	found := make([][]string, len(addrs))
+	for _, s := range generatedSets(cfg) {
+		items, err := loadSet(s, readBinary)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			p, err := parsePrefix(item)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.Path, err)
+			}
+			for i, addr := range addrs {
+				if p.Contains(addr) {
+					found[i] = append(found[i], fmt.Sprintf("%s (%s)", s.Name, p))
+				}
+			}
+		}
+	}
+
+	for i, addr := range addrs {
+		if len(found[i]) == 0 {
+			fmt.Printf("%s\tnot found\n", addr)
+			continue
+		}
+		for _, f := range found[i] {
+			fmt.Printf("%s\t%s\n", addr, f)
+		}
+	}
+	return nil
+}
+
+// runValidate checks that every generated set parses and only holds valid
+// prefixes of its address family.
+func runValidate(args []string) error {
+	var readBinary bool
+	cfg, _, err := loadConfig("validate", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.BoolVar(&readBinary, "read-binary", false, "read the binary (.bin) copies of the sets")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	var problems int
+	for _, s := range generatedSets(cfg) {
+		items, err := loadSet(s, readBinary)
+		if err != nil {
+			return err
+		}
+		want6 := s.AddrType == "ipv6_addr"
+		for _, item := range items {
+			p, err := parsePrefix(item)
+			if err != nil {
+				logWarn(fmt.Sprintf("set %s: invalid element %q", s.Name, item))
+				problems++
+				continue
+			}
+			if p.Addr().Is6() != want6 {
+				logWarn(fmt.Sprintf("set %s: %s does not belong in a %s set", s.Name, item, s.AddrType))
+				problems++
+			}
+		}
+		if len(items) == 0 {
+			logWarn(fmt.Sprintf("set %s is empty", s.Name))
+			problems++
+			continue
+		}
+		logInfo(fmt.Sprintf("set %s: %d elements", s.Name, len(items)))
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
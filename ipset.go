@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// ipsetMinMaxelem is the smallest maxelem of the generated ipsets, the
+// ipset default.
+const ipsetMinMaxelem = 65536
+
+// ipsetMaxelem returns the maxelem for a set of n elements. It is rounded up
+// to a power of two: `create -exist` fails when the live set was created with
+// different parameters, so they should only change when a set outgrows them.
+func ipsetMaxelem(n int) int {
+	m := ipsetMinMaxelem
+	for m < n {
+		m *= 2
+	}
+	return m
+}
+
+// writeIPSet writes sets in the `ipset restore` format. The elements go into a
+// temporary set that is swapped with the live one, so restoring the file
+// while iptables rules reference the set replaces its contents atomically.
+func writeIPSet(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		for _, s := range sets {
+			family := "inet"
+			if s.Family == "ipv6" {
+				family = "inet6"
+			}
+			maxelem := ipsetMaxelem(len(s.Elements))
+			tmp := s.Name + "-new"
+
+			fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", s.Name, family, maxelem)
+			fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", tmp, family, maxelem)
+			fmt.Fprintf(w, "flush %s\n", tmp)
+			for _, item := range s.Elements {
+				fmt.Fprintf(w, "add %s %s\n", tmp, item)
+			}
+			fmt.Fprintf(w, "swap %s %s\n", tmp, s.Name)
+			fmt.Fprintf(w, "destroy %s\n", tmp)
+		}
+		return nil
+	})
+}
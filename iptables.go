@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// iptablesChain is the name of the chain holding the rules of set s, e.g.
+// GEOIP-CN4.
+func iptablesChain(s setSpec) string {
+	return "GEOIP-" + strings.ToUpper(s.Name)
+}
+
+// writeIPTables writes an iptables-restore (ip6tables-restore for IPv6
+// sets) fragment with a chain per set that sends matching source addresses
+// to -iptables-target. The rule matches the ipset of the same name, or with
+// -iptables-raw every prefix on its own. Declaring the chain flushes it when
+// the file is loaded with --noflush, so reloading replaces the old rules.
+func writeIPTables(cfg *Config, path string, sets []setSpec) error {
+	return writeLines(path, func(w *bufio.Writer) error {
+		fmt.Fprintln(w, "*filter")
+		for _, s := range sets {
+			fmt.Fprintf(w, ":%s - [0:0]\n", iptablesChain(s))
+		}
+		for _, s := range sets {
+			chain := iptablesChain(s)
+			if !cfg.IPTablesRaw {
+				fmt.Fprintf(w, "-A %s -m set --match-set %s src -j %s\n", chain, s.Name, cfg.IPTablesTarget)
+				continue
+			}
+			for _, item := range s.Elements {
+				fmt.Fprintf(w, "-A %s -s %s -j %s\n", chain, item, cfg.IPTablesTarget)
+			}
+		}
+		fmt.Fprintln(w, "COMMIT")
+		return nil
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockHolderCheckInterval is how often a waiting run checks whether the
+// process recorded in the lock file still exists.
+const lockHolderCheckInterval = 10 * time.Second
+
+// lockPath returns the lock_file of the config, defaulting to a file next to
+// the state file.
+func (c *Config) lockPath() string {
+	if c.LockFile != "" {
+		return c.LockFile
+	}
+	return filepath.Join(filepath.Dir(c.StateFile), "update.lock")
+}
+
+// acquireLock takes an exclusive flock on path so only one update runs at a
+// time. When the lock is held it retries every second for up to timeout; a
+// zero timeout fails right away. The returned function releases the lock.
+func acquireLock(path string, timeout time.Duration) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	var lastCheck time.Time
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+
+		pid := lockHolder(path)
+		holder := "another process"
+		if pid > 0 {
+			holder = fmt.Sprintf("pid %d", pid)
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			if timeout > 0 {
+				return nil, fmt.Errorf("%s still holds %s after %s", holder, path, timeout)
+			}
+			return nil, fmt.Errorf("%s holds %s, another update is running (use -lock-timeout to wait)", holder, path)
+		}
+		if time.Since(lastCheck) >= lockHolderCheckInterval {
+			lastCheck = time.Now()
+			if pid > 0 && !processAlive(pid) {
+				logWarn(fmt.Sprintf("Lock holder pid %d has exited but %s is still locked, probably by a process it started", pid, path))
+			} else {
+				logInfo(fmt.Sprintf("Waiting for %s to release %s...", holder, path))
+			}
+		}
+		time.Sleep(time.Second)
+	}
+
+	// Record our pid for anyone waiting on the lock.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// lockHolder returns the pid recorded in the lock file, or 0.
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// processAlive reports whether pid exists. Signal 0 only checks for it; EPERM
+// means it exists but belongs to another user.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -1,211 +1,558 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
-
-	maxminddb "github.com/oschwald/maxminddb-golang"
 )
 
 const (
-	apiURL   = "https://api.github.com/repos/P3TERX/GeoLite.mmdb/releases/latest"
-	saveMMDB = "/usr/share/GeoIP/GeoLite2-Country.mmdb"
-	tmpMMDB  = "./GeoLite2-Country.mmdb"
+	githubAPI         = "https://api.github.com"
+	defaultGitHubRepo = "P3TERX/GeoLite.mmdb"
+	defaultMMDBAsset  = "GeoLite2-Country.mmdb"
+	defaultTmpMMDB    = "./GeoLite2-Country.mmdb"
 
-	outCN4 = "/etc/nftables.d/cn4.nft"
-	outCN6 = "/etc/nftables.d/cn6.nft"
+	systemMMDB   = "/usr/share/GeoIP/GeoLite2-Country.mmdb"
+	systemOutDir = "/etc/nftables.d"
 )
 
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
 type GitHubAsset struct {
 	Name               string `json:"name"`
+	Size               int64  `json:"size"`
 	BrowserDownloadURL string `json:"browser_download_url"`
 }
 
 type GitHubRelease struct {
-	TagName string        `json:"tag_name"`
-	Assets  []GitHubAsset `json:"assets"`
+	TagName     string        `json:"tag_name"`
+	PublishedAt time.Time     `json:"published_at"`
+	Assets      []GitHubAsset `json:"assets"`
 }
 
 type CountryRecord struct {
 	Country struct {
-		ISOCode string `maxminddb:"iso_code"`
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
 	} `maxminddb:"country"`
+	Continent struct {
+		Code  string            `maxminddb:"code"`
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	// Subdivisions are only in the City database, from the largest to
+	// the smallest.
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]int{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// logLevel is the minimum level that gets printed.
+var logLevel = levelInfo
+
+func logAt(level int, label, msg string) {
+	if level < logLevel {
+		return
+	}
+	fmt.Printf("[%s] %s: %s\n", time.Now().Format(time.RFC3339), label, msg)
+}
+
+func logDebug(msg string) {
+	logAt(levelDebug, "DEBUG", msg)
 }
 
 func logInfo(msg string) {
-	fmt.Printf("[%s] INFO: %s\n", time.Now().Format(time.RFC3339), msg)
+	logAt(levelInfo, "INFO", msg)
+}
+
+func logWarn(msg string) {
+	logAt(levelWarn, "WARN", msg)
 }
 
 func logErr(err error) {
-	fmt.Printf("[%s] ERROR: %v\n", time.Now().Format(time.RFC3339), err)
+	logAt(levelError, "ERROR", err.Error())
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
+// bold highlights msg when stdout is a terminal.
+func bold(msg string) string {
+	if !isTerminal(os.Stdout) {
+		return msg
 	}
-	defer destFile.Close()
+	return "\033[1m" + msg + "\033[0m"
+}
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+// releasesURL returns the GitHub API endpoint listing the releases of repo.
+func releasesURL(repo string) string {
+	return githubAPI + "/repos/" + repo + "/releases"
 }
 
-func main() {
+// fetchLatestRelease queries the GitHub API for the latest release metadata.
+// With cache the request is conditional, see githubGet.
+func fetchLatestRelease(ctx context.Context, repo string, cache *apiCache) (*GitHubRelease, error) {
 	logInfo("Fetching latest GitHub release metadata...")
 
-	// 1. Fetch GitHub release info
-	resp, err := http.Get(apiURL)
+	var release GitHubRelease
+	if err := githubGet(ctx, releasesURL(repo)+"/latest", cache, &release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// githubToken authenticates GitHub API requests when set, raising the rate
+// limit from 60 to 5000 requests an hour.
+var githubToken string
+
+// apiCache holds the validators of an earlier GitHub API response.
+type apiCache struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// errNotModified is returned by githubGet and downloadFile when the response
+// cached for the URL is still current.
+var errNotModified = errors.New("not modified")
+
+// githubGet fetches a GitHub API URL and decodes the JSON response into v.
+// When cache holds validators for url they are sent along, and a 304 response
+// yields errNotModified; cache is updated from every successful response.
+// GitHub doesn't count 304 responses against the rate limit.
+func githubGet(ctx context.Context, url string, cache *apiCache, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		logErr(err)
-		os.Exit(1)
+		return err
+	}
+	if cache != nil && cache.URL == url {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if githubToken != "" {
+		req.Header.Set("Authorization", "Bearer "+githubToken)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
-
-	var release GitHubRelease
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		logErr(err)
-		os.Exit(1)
+	if n := resp.Header.Get("X-RateLimit-Remaining"); n != "" {
+		logDebug("GitHub API requests left in this rate limit window: " + n)
 	}
 
-	logInfo("Latest tag: " + release.TagName)
+	if resp.StatusCode == http.StatusNotModified && cache != nil {
+		return errNotModified
+	}
+	if err := githubRateLimitError(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && githubToken != "" {
+		return fmt.Errorf("GitHub API rejected the token: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{msg: "GitHub API request failed", code: resp.StatusCode, status: resp.Status}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return err
+	}
+	if cache != nil {
+		*cache = apiCache{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	}
+	return nil
+}
 
-	// 2. Find mmdb download URL
-	var downloadURL string
-	for _, a := range release.Assets {
-		if a.Name == "GeoLite2-Country.mmdb" {
-			downloadURL = a.BrowserDownloadURL
-			break
+// githubRateLimitError explains a 403 or 429 response caused by the primary
+// rate limit, with the time it resets, or by a secondary rate limit.
+func githubRateLimitError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	hint := ""
+	if githubToken == "" {
+		hint = "; set GITHUB_TOKEN or -github-token to raise the limit"
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("GitHub API rate limit exceeded%s", hint)
 		}
+		at := time.Unix(reset, 0)
+		return fmt.Errorf("GitHub API rate limit exceeded, it resets at %s (in %s)%s", at.Format(time.RFC3339), time.Until(at).Round(time.Second), hint)
 	}
-	if downloadURL == "" {
-		logErr(fmt.Errorf("GeoLite2-Country.mmdb not found in release"))
-		os.Exit(1)
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		return fmt.Errorf("GitHub API secondary rate limit hit, retry after %ss%s", after, hint)
 	}
+	return nil
+}
 
-	logInfo("MMDB download URL: " + downloadURL)
-
-	// 3. Download mmdb
-	logInfo("Downloading MMDB...")
+// defaultMaxDownloadSize caps downloads when -max-download-size is not set.
+const defaultMaxDownloadSize = 500 << 20
+
+// downloadOptions tune a single downloadFile call.
+type downloadOptions struct {
+	Header   http.Header
+	User     string
+	Password string
+	// MaxSize is the largest accepted body in bytes. When zero the limit is
+	// three times the announced Content-Length, capped at
+	// defaultMaxDownloadSize.
+	MaxSize int64
+	// Decompress gunzips the body. Downloads whose path ends in .gz or that
+	// arrive with Content-Encoding: gzip are decompressed regardless.
+	Decompress bool
+	// Tee, when set, is fed the body as received, before decompression,
+	// for checksum and signature verification.
+	Tee io.Writer
+	// Size is the declared size of the file, such as that of a release
+	// asset, or zero when unknown.
+	Size int64
+	// Progress, when set, reports the transfer every Progress, or with a
+	// progress bar on a terminal, and logs its statistics at the end.
+	Progress time.Duration
+	// RateLimit caps the transfer in bytes per second when set.
+	RateLimit int64
+	// Cache, when set, works like in githubGet: validators cached for the
+	// URL make the request conditional, a 304 response yields
+	// errNotModified, and a complete download updates it.
+	Cache *apiCache
+}
 
-	out, err := os.Create(tmpMMDB)
+// downloadFile fetches url into path. The body is first written as received
+// to <path>.part: when the transfer breaks off with a retryable error, the
+// next call for the same url resumes it with a Range request rather than
+// starting over. Once complete, and matching the announced size and
+// opts.Size, the part is fed to opts.Tee and decompressed into path. It is
+// removed when the download fails otherwise or exceeds the size limit.
+func downloadFile(ctx context.Context, path, url string, opts downloadOptions) error {
+	if err := simulatedDownloadError(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		logErr(err)
-		os.Exit(1)
+		return err
+	}
+	for name, values := range opts.Header {
+		req.Header[name] = values
+	}
+	if opts.User != "" || opts.Password != "" {
+		req.SetBasicAuth(opts.User, opts.Password)
+	}
+	part, offset := loadPartial(path, url)
+	if part != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", part.Validator)
+	} else if c := opts.Cache; c != nil && c.URL == url {
+		if c.ETag != "" {
+			req.Header.Set("If-None-Match", c.ETag)
+		}
+		if c.LastModified != "" {
+			req.Header.Set("If-Modified-Since", c.LastModified)
+		}
 	}
-	defer out.Close()
 
-	resp2, err := http.Get(downloadURL)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		logErr(err)
-		os.Exit(1)
+		return redactError(err)
 	}
-	defer resp2.Body.Close()
+	defer resp.Body.Close()
 
-	if resp2.StatusCode != 200 {
-		logErr(fmt.Errorf("download failed: %d", resp2.StatusCode))
-		os.Exit(1)
+	total := resp.ContentLength
+	switch {
+	case resp.StatusCode == http.StatusNotModified && opts.Cache != nil:
+		return errNotModified
+	case resp.StatusCode == http.StatusOK:
+		part, offset = nil, 0
+	case resp.StatusCode == http.StatusPartialContent && part != nil:
+		first, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && first != offset {
+			err = fmt.Errorf("got a range starting at byte %d", first)
+		}
+		if err != nil {
+			removePartial(path)
+			return temporaryError{fmt.Errorf("cannot resume the download at byte %d: %w", offset, err)}
+		}
+		total = size
+		if total < 0 {
+			total = part.Size
+		}
+		logInfo(fmt.Sprintf("Resuming the download at byte %d.", offset))
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && part != nil:
+		removePartial(path)
+		return temporaryError{fmt.Errorf("cannot resume the download at byte %d: %s", offset, resp.Status)}
+	default:
+		return &statusError{msg: "download failed", code: resp.StatusCode, status: resp.Status}
+	}
+	if opts.Size > 0 {
+		if total >= 0 && total != opts.Size {
+			removePartial(path)
+			return fmt.Errorf("download is %d bytes, the release lists %d for the asset", total, opts.Size)
+		}
+		total = opts.Size
+	}
+
+	gz := opts.Decompress || isGzipPath(req.URL.Path) ||
+		(resp.Header.Get("Content-Encoding") == "gzip" && !resp.Uncompressed)
+	size := total
+	if gz {
+		// The announced size is the compressed one.
+		size = -1
+	}
+	limit := downloadLimit(opts.MaxSize, size)
+	if total > limit {
+		removePartial(path)
+		return fmt.Errorf("download too large: %d bytes announced, limit is %d", total, limit)
 	}
 
-	_, err = io.Copy(out, resp2.Body)
+	flag := os.O_WRONLY | os.O_APPEND
+	if part == nil {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		part = &partial{URL: url, Validator: resumeValidator(resp), Size: total}
+		if part.Validator != "" {
+			if err := savePartial(path, part); err != nil {
+				return err
+			}
+		} else {
+			os.Remove(partInfoPath(path))
+		}
+	}
+	out, err := os.OpenFile(partPath(path), flag, 0644)
 	if err != nil {
-		logErr(err)
-		os.Exit(1)
+		return err
+	}
+	var body io.Reader = resp.Body
+	if opts.RateLimit > 0 {
+		body = newThrottledReader(body, opts.RateLimit)
+	}
+	var prog *progress
+	if opts.Progress > 0 {
+		prog = newProgress(offset, total, opts.Progress)
+		body = io.TeeReader(body, prog)
+	}
+	n, err := io.Copy(out, io.LimitReader(body, limit-offset+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	got := offset + n
+	switch {
+	case err != nil:
+	case got > limit:
+		err = fmt.Errorf("download exceeded the limit of %d bytes", limit)
+	case total >= 0 && got < total:
+		err = temporaryError{fmt.Errorf("download incomplete: got %d of %d bytes", got, total)}
+	case total >= 0 && got > total:
+		err = fmt.Errorf("download is larger than the announced %d bytes", total)
+	}
+	if prog != nil {
+		if err != nil {
+			prog.clear()
+		} else {
+			prog.finish()
+		}
+	}
+	if err != nil {
+		if part.Validator == "" || got > limit || !retryable(err) {
+			removePartial(path)
+		} else {
+			logDebug(fmt.Sprintf("Kept %d bytes of the download to resume it", got))
+		}
+		return err
 	}
 
-	logInfo("Download complete.")
+	defer removePartial(path)
+	f, err := os.Open(partPath(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := saveBody(path, teeBody(f, opts.Tee), limit, gz); err != nil {
+		return err
+	}
+	if opts.Cache != nil {
+		*opts.Cache = apiCache{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	}
+	return nil
+}
 
-	// 4. Replace system MMDB
-	logInfo("Replacing old MMDB...")
-	if err := copyFile(tmpMMDB, saveMMDB); err != nil {
-		logErr(err)
-		os.Exit(1)
+// teeBody returns r, copying everything read from it to w when w is set.
+func teeBody(r io.Reader, w io.Writer) io.Reader {
+	if w == nil {
+		return r
 	}
-	os.Remove(tmpMMDB) // Clean up temp file
+	return io.TeeReader(r, w)
+}
 
-	// 5. Parse MMDB and extract CN networks
-	logInfo("Parsing MMDB and generating nftables sets...")
+func isGzipPath(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz")
+}
 
-	db, err := maxminddb.Open(saveMMDB)
-	if err != nil {
-		logErr(err)
-		os.Exit(1)
+// downloadLimit returns the size limit for a download announcing size bytes
+// (or -1 when unknown), see downloadOptions.MaxSize.
+func downloadLimit(maxSize, size int64) int64 {
+	if maxSize != 0 {
+		return maxSize
 	}
-	defer db.Close()
-
-	var cnIPv4 []string
-	var cnIPv6 []string
+	limit := int64(defaultMaxDownloadSize)
+	if size > 0 && 3*size < limit {
+		limit = 3 * size
+	}
+	return limit
+}
 
-	// Iterate over all networks
-	networks := db.Networks(maxminddb.SkipAliasedNetworks)
-	for networks.Next() {
-		var rec CountryRecord
-		network, err := networks.Network(&rec)
+// saveBody writes r to path, gunzipping it first when gz is set, and fails
+// once more than limit bytes are written. The partial file is removed on
+// error.
+func saveBody(path string, r io.Reader, limit int64, gz bool) error {
+	if gz {
+		zr, err := gzip.NewReader(r)
 		if err != nil {
-			continue
+			return fmt.Errorf("decompress download: %w", err)
 		}
+		defer zr.Close()
+		r = zr
+	}
 
-		if rec.Country.ISOCode == "CN" {
-			_, ipNet, err := net.ParseCIDR(network.String())
-			if err != nil {
-				continue
-			}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(out, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("download exceeded the limit of %d bytes", limit)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// exitReloadTimeout is the exit status of an update whose reload command
+// was killed by -timeout-nft.
+const exitReloadTimeout = 3
+
+// subcommands maps the first command-line argument to its handler. Without a
+// known subcommand the tool performs an update.
+var subcommands = map[string]func(args []string) error{
+	"check-prereqs":          runCheckPrereqs,
+	"country-code-from-file": runCountryCodeFromFile,
+	"generate-docker":        runGenerateDocker,
+	"list-releases":          runListReleases,
+	"lookup":                 runLookup,
+	"merge":                  runMerge,
+	"rank":                   runRank,
+	"rollback":               runRollback,
+	"validate":               runValidate,
+	"verify-live":            runVerifyLive,
+}
 
-			if ipNet.IP.To4() != nil {
-				cnIPv4 = append(cnIPv4, ipNet.String())
-			} else {
-				cnIPv6 = append(cnIPv6, ipNet.String())
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				logErr(err)
+				os.Exit(1)
 			}
+			return
 		}
 	}
 
-	// 6. Write nftables set files
-	writeSetFile(outCN4, "cn4", "ipv4_addr", cnIPv4)
-	writeSetFile(outCN6, "cn6", "ipv6_addr", cnIPv6)
+	cfg, _, err := loadConfig(os.Args[0], os.Args[1:], (*Config).bindFlags)
+	if err != nil {
+		logErr(err)
+		os.Exit(2)
+	}
+	if err := cfg.apply(); err != nil {
+		logErr(err)
+		os.Exit(2)
+	}
 
-	logInfo("Generated:")
-	logInfo(fmt.Sprintf("- %s (%d IPv4 ranges)", outCN4, len(cnIPv4)))
-	logInfo(fmt.Sprintf("- %s (%d IPv6 ranges)", outCN6, len(cnIPv6)))
+	if err := startOTel(cfg); err != nil {
+		logErr(err)
+		os.Exit(2)
+	}
 
-	// 7. Reload nftables
-	logInfo("Reloading nftables...")
-	cmd := exec.Command("systemctl", "restart", "nftables")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		logErr(fmt.Errorf("systemctl output: %s", string(out)))
-		os.Exit(1)
+	if w := simulationWarning(); w != "" {
+		logWarn(bold(w))
 	}
 
-	logInfo("Done.")
-}
+	if cfg.Daemon {
+		if err := runDaemon(cfg, os.Args[0], os.Args[1:]); err != nil {
+			logErr(err)
+			os.Exit(1)
+		}
+		return
+	}
 
-func writeSetFile(path, setName, addrType string, items []string) {
-	f, err := os.Create(path)
-	if err != nil {
+	// SIGINT and SIGTERM abandon a running download, parse or reload.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runUpdate(ctx, cfg); err != nil {
 		logErr(err)
+		if errors.Is(err, errReloadTimeout) {
+			os.Exit(exitReloadTimeout)
+		}
 		os.Exit(1)
 	}
-	defer f.Close()
-
-	fmt.Fprintf(f, "set %s {\n", setName)
-	fmt.Fprintf(f, "    type %s\n", addrType)
-	fmt.Fprintf(f, "    flags interval\n")
-	fmt.Fprintf(f, "    elements = {\n")
+}
 
-	for _, n := range items {
-		fmt.Fprintf(f, "        %s,\n", n)
+// localizedName picks the name for lang from an MMDB names map. A bare
+// language such as "zh" also matches regional keys like "zh-CN", and English
+// is used when the language is missing.
+func localizedName(names map[string]string, lang string) string {
+	if n, ok := names[lang]; ok {
+		return n
+	}
+	for key, n := range names {
+		if strings.HasPrefix(key, lang+"-") {
+			return n
+		}
 	}
+	return names["en"]
+}
 
-	fmt.Fprintf(f, "    }\n}\n")
+// writeCountryMetadata writes a JSON object mapping ISO codes to names.
+func writeCountryMetadata(path string, names map[string]string) error {
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxmindDownloadURL is the base of MaxMind's database download endpoint.
+var maxmindDownloadURL = "https://download.maxmind.com/geoip/databases"
+
+// maxmindEditionRe matches a MaxMind edition ID such as GeoLite2-Country.
+var maxmindEditionRe = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// maxmindURL returns the download URL of edition as suffix, tar.gz for the
+// database or tar.gz.sha256 for its checksum.
+func maxmindURL(edition, suffix string) string {
+	return maxmindDownloadURL + "/" + edition + "/download?suffix=" + suffix
+}
+
+// fetchMaxMindChecksum downloads the SHA256 of the tar.gz of -maxmind-edition,
+// a sha256sum line naming the dated archive.
+func fetchMaxMindChecksum(ctx context.Context, cfg *Config, opts downloadOptions) (string, error) {
+	rawURL := maxmindURL(cfg.MaxMindEdition, "tar.gz.sha256")
+	data, err := fetchSidecar(ctx, cfg, rawURL, rawURL, opts)
+	if err != nil {
+		return "", fmt.Errorf("download checksum %s: %w", rawURL, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s: empty checksum file", rawURL)
+	}
+	return normalizeSHA256(fields[0])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+// runMerge combines already generated set files of one address family into a
+// single aggregated set.
+func runMerge(args []string) error {
+	var output, inputs, setName string
+	cfg, fs, err := loadConfig("merge", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.StringVar(&output, "output", "", "path of the merged set file")
+		fs.StringVar(&inputs, "inputs", "", "comma-separated list of set files to merge")
+		fs.StringVar(&setName, "set-name", "", "name of the merged set (default: output file name without extension)")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	if output == "" || inputs == "" {
+		fs.Usage()
+		return fmt.Errorf("-output and -inputs are required")
+	}
+	if setName == "" {
+		setName = strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+	}
+	if !setNameRe.MatchString(setName) {
+		return fmt.Errorf("invalid set name %q, want a letter followed by letters, digits, _ or . (set -set-name)", setName)
+	}
+
+	var prefixes []netip.Prefix
+	var family, familyFrom string
+	for _, path := range strings.Split(inputs, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		items, err := readSetFile(path)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			p, err := parsePrefix(item)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			f := "ipv6"
+			if p.Addr().Is4() {
+				f = "ipv4"
+			}
+			if family == "" {
+				family, familyFrom = f, path
+			} else if f != family {
+				return fmt.Errorf("%s contains %s elements but %s contains %s elements", path, f, familyFrom, family)
+			}
+			prefixes = append(prefixes, p)
+		}
+		logInfo(fmt.Sprintf("Read %d elements from %s", len(items), path))
+	}
+	if family == "" {
+		return fmt.Errorf("the input sets contain no elements")
+	}
+
+	merged := aggregatePrefixes(prefixes)
+	elems := make([]string, len(merged))
+	for i, p := range merged {
+		elems[i] = p.String()
+	}
+	style, err := cfg.nftStyle()
+	if err != nil {
+		return err
+	}
+	elems, style = style.forElements(elems)
+	if _, err := writeSetFile(context.Background(), output, setSpec{Name: setName, AddrType: family + "_addr"}, sendAll(elems), style); err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("Generated: %s (%d %s ranges)", output, len(elems), familyLabel(family)))
+	return nil
+}
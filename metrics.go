@@ -0,0 +1,77 @@
+package main
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// updateMetrics collects the numbers exported to the metrics backends. It is
+// safe for concurrent use.
+type updateMetrics struct {
+	mu           sync.Mutex
+	updates      int64
+	failures     int64
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	download     time.Duration
+	tag          string
+	setElements  map[string]int
+}
+
+// metricsSnapshot is a consistent copy of updateMetrics.
+type metricsSnapshot struct {
+	Updates      int64
+	Failures     int64
+	LastSuccess  time.Time
+	LastDuration time.Duration
+	// Download is the duration of the last MMDB download and Tag the
+	// release it came from ("" for -mmdb-url and -ftp-url).
+	Download    time.Duration
+	Tag         string
+	SetElements map[string]int
+}
+
+var stats = &updateMetrics{setElements: map[string]int{}}
+
+// recordUpdate counts one finished update that started at start.
+func (m *updateMetrics) recordUpdate(start time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updates++
+	m.lastDuration = time.Since(start)
+	if err != nil {
+		m.failures++
+		return
+	}
+	m.lastSuccess = time.Now()
+}
+
+// recordDownload stores the duration of an MMDB download from release tag.
+func (m *updateMetrics) recordDownload(tag string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tag = tag
+	m.download = d
+}
+
+// recordSet stores the number of elements written to a set.
+func (m *updateMetrics) recordSet(name string, elements int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setElements[name] = elements
+}
+
+func (m *updateMetrics) snapshot() metricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return metricsSnapshot{
+		Updates:      m.updates,
+		Failures:     m.failures,
+		LastSuccess:  m.lastSuccess,
+		LastDuration: m.lastDuration,
+		Download:     m.download,
+		Tag:          m.tag,
+		SetElements:  maps.Clone(m.setElements),
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// writeMikroTik writes a RouterOS script that replaces the address list named
+// after the country (e.g. CN) with the prefixes of sets, under
+// /ip firewall address-list for IPv4 and /ipv6 firewall address-list for
+// IPv6.
+func writeMikroTik(cfg *Config, path string, sets []setSpec) error {
+	list := strings.ToUpper(sets[0].Country)
+	return writeLines(path, func(w *bufio.Writer) error {
+		for _, s := range sets {
+			menu := "/ip firewall address-list"
+			if s.Family == "ipv6" {
+				menu = "/ipv6 firewall address-list"
+			}
+			fmt.Fprintln(w, menu)
+			fmt.Fprintf(w, "remove [find list=%s]\n", list)
+			for _, item := range s.Elements {
+				fmt.Fprintf(w, "add list=%s address=%s\n", list, item)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// mirrorURLs returns rawURL followed by its location on every -mirror. A
+// mirror containing {url} or {name} has them replaced with rawURL and its
+// file name; any other mirror is a prefix rawURL is appended to, as
+// ghproxy-style services expect. FTP downloads have no mirrors.
+func mirrorURLs(cfg *Config, rawURL string) []string {
+	urls := []string{rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "ftp" {
+		return urls
+	}
+	for _, m := range cfg.Mirrors {
+		if strings.Contains(m, "{url}") || strings.Contains(m, "{name}") {
+			urls = append(urls, strings.NewReplacer("{url}", rawURL, "{name}", path.Base(u.Path)).Replace(m))
+		} else {
+			urls = append(urls, m+rawURL)
+		}
+	}
+	return urls
+}
+
+// signatureError is a download whose GPG signature did not verify.
+type signatureError struct {
+	url string
+	err error
+}
+
+func (e *signatureError) Error() string {
+	return e.err.Error()
+}
+
+// downloadMirrored downloads rawURL into cfg.TmpPath with verifiedDownload,
+// trying the -mirror locations in order when it fails. A bad signature is
+// final: another copy of the file is not tried.
+func downloadMirrored(ctx context.Context, cfg *Config, rawURL string, opts downloadOptions, sha hash.Hash, sig *signature) error {
+	urls := mirrorURLs(cfg, rawURL)
+	var err error
+	for i, u := range urls {
+		if i > 0 {
+			logWarn(fmt.Sprintf("Download from %s failed: %v, trying %s", redactURL(urls[i-1]), err, redactURL(u)))
+			// Credentials and headers of -mmdb-url stay with its host.
+			opts.Header, opts.User, opts.Password = nil, "", ""
+		}
+		err = verifiedDownload(ctx, cfg, u, opts, sha, sig)
+		var serr *signatureError
+		if err == nil || errors.As(err, &serr) || errors.Is(err, errNotModified) {
+			return err
+		}
+	}
+	return err
+}
+
+// verifiedDownload downloads rawURL into cfg.TmpPath, hashing the body into
+// sha, which is reset first, and checking it against sig when set.
+func verifiedDownload(ctx context.Context, cfg *Config, rawURL string, opts downloadOptions, sha hash.Hash, sig *signature) error {
+	sha.Reset()
+	tees := []io.Writer{sha}
+	var check *signatureCheck
+	if sig != nil {
+		check = sig.start()
+		tees = append(tees, check)
+	}
+	opts.Tee = io.MultiWriter(tees...)
+
+	var err error
+	if strings.HasPrefix(rawURL, "ftp://") {
+		err = downloadFTP(ctx, cfg.TmpPath, rawURL, opts)
+	} else {
+		err = downloadFile(ctx, cfg.TmpPath, rawURL, opts)
+	}
+	if check != nil {
+		if serr := check.finish(err); err == nil && serr != nil {
+			return &signatureError{url: rawURL, err: serr}
+		}
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// probeIPs are looked up in every downloaded MMDB to make sure its records
+// decode as country data.
+var probeIPs = []string{"8.8.8.8", "2001:4860:4860::8888"}
+
+// validateMMDB checks that path is a usable MMDB before it replaces the
+// installed one: the metadata is sane, every network of the search tree
+// decodes as a record of src, and so does a lookup of probeIPs. Reader.Verify
+// is not used, it rejects databases without a description although the
+// format makes it optional.
+func validateMMDB(path string, src geoSource) (maxminddb.Metadata, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return maxminddb.Metadata{}, err
+	}
+	defer db.Close()
+
+	m := db.Metadata
+	switch {
+	case m.DatabaseType == "":
+		return m, fmt.Errorf("metadata has no database_type")
+	case m.NodeCount == 0:
+		return m, fmt.Errorf("search tree has no nodes")
+	case m.IPVersion != 4 && m.IPVersion != 6:
+		return m, fmt.Errorf("unsupported ip_version %d", m.IPVersion)
+	}
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	var n int
+	for networks.Next() {
+		if _, _, err := nextRecord(src, networks); err != nil {
+			return m, err
+		}
+		n++
+	}
+	if err := networks.Err(); err != nil {
+		return m, err
+	}
+	if n == 0 {
+		return m, fmt.Errorf("database has no networks")
+	}
+	for _, s := range probeIPs {
+		ip := net.ParseIP(s)
+		if ip.To4() == nil && m.IPVersion == 4 {
+			continue
+		}
+		if _, err := lookupRecord(src, db, ip); err != nil {
+			return m, fmt.Errorf("test lookup of %s: %w", s, err)
+		}
+	}
+	logInfo(fmt.Sprintf("MMDB is valid: %s built %s, %d networks", m.DatabaseType, buildTime(m.BuildEpoch), n))
+	return m, nil
+}
+
+// checkDowngrade refuses to replace the installed MMDB with one built
+// earlier, or at the same time but with different content, unless
+// -allow-downgrade is set. Nothing is checked when no readable MMDB is
+// installed.
+func checkDowngrade(cfg *Config, downloaded maxminddb.Metadata, unchanged bool) error {
+	if cfg.AllowDowngrade || unchanged {
+		return nil
+	}
+	db, err := maxminddb.Open(cfg.MMDBPath)
+	if err != nil {
+		return nil
+	}
+	installed := db.Metadata.BuildEpoch
+	db.Close()
+	if downloaded.BuildEpoch > installed {
+		return nil
+	}
+	return fmt.Errorf("the downloaded MMDB was built %s, the installed one %s; refusing to replace it with one that is not newer (use -allow-downgrade to install it anyway)", buildTime(downloaded.BuildEpoch), buildTime(installed))
+}
+
+func buildTime(epoch uint) string {
+	return time.Unix(int64(epoch), 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/nftables"
+)
+
+// nftFamilies maps the family of -nft-table to its netlink value.
+var nftFamilies = map[string]nftables.TableFamily{
+	"ip":     nftables.TableFamilyIPv4,
+	"ip6":    nftables.TableFamilyIPv6,
+	"inet":   nftables.TableFamilyINet,
+	"arp":    nftables.TableFamilyARP,
+	"bridge": nftables.TableFamilyBridge,
+	"netdev": nftables.TableFamilyNetdev,
+}
+
+// reloadNetlink programs the generated sets into -nft-table over netlink,
+// without the nft binary: like the -reload-mode sets script, each set is
+// created if missing, flushed and refilled from its file, all in one batch
+// the kernel applies as a single transaction.
+func reloadNetlink(ctx context.Context, cfg *Config) error {
+	familyName, tableName, _ := strings.Cut(cfg.NftTable, " ")
+	family, ok := nftFamilies[familyName]
+	if !ok {
+		return fmt.Errorf("-reload-mode netlink: unknown nftables family %q", familyName)
+	}
+
+	var opts []nftables.ConnOption
+	if cfg.Netns != "" {
+		ns, err := os.Open(netnsFile(cfg.Netns))
+		if err != nil {
+			return err
+		}
+		defer ns.Close()
+		opts = append(opts, nftables.WithNetNSFd(int(ns.Fd())))
+	}
+	conn, err := nftables.New(opts...)
+	if err != nil {
+		return fmt.Errorf("netlink: %w", err)
+	}
+
+	table := conn.AddTable(&nftables.Table{Family: family, Name: tableName})
+	for _, s := range generatedSets(cfg) {
+		decl, err := setDeclaration(s.Path)
+		if err != nil {
+			return err
+		}
+		items, err := readSetFile(s.Path)
+		if err != nil {
+			return err
+		}
+		set := &nftables.Set{
+			Table:    table,
+			Name:     s.Name,
+			KeyType:  nftables.TypeIPAddr,
+			Interval: strings.Contains(decl, "interval"),
+		}
+		if s.Family == "ipv6" {
+			set.KeyType = nftables.TypeIP6Addr
+		}
+		elems, err := netlinkElements(items, set.Interval)
+		if err != nil {
+			return fmt.Errorf("set %s: %w", s.Name, err)
+		}
+		if err := conn.AddSet(set, nil); err != nil {
+			return fmt.Errorf("set %s: %w", s.Name, err)
+		}
+		conn.FlushSet(set)
+		// An interval takes two elements, keep them in one message.
+		for batch := range slices.Chunk(elems, 2*setsScriptBatch) {
+			if err := conn.SetAddElements(set, batch); err != nil {
+				return fmt.Errorf("set %s: %w", s.Name, err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logDebug(fmt.Sprintf("Sending the sets to table %s over netlink", cfg.NftTable))
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("netlink: %w", err)
+	}
+	return nil
+}
+
+// netlinkElements converts set file elements to netlink set elements. An
+// interval set gets the start of every prefix and, flagged as the interval
+// end, the address after it, which the last prefix of the address space
+// lacks.
+func netlinkElements(items []string, interval bool) ([]nftables.SetElement, error) {
+	var elems []nftables.SetElement
+	for _, item := range items {
+		p, err := parsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, nftables.SetElement{Key: p.Addr().AsSlice()})
+		if end := prefixLast(p).Next(); interval && end.IsValid() {
+			elems = append(elems, nftables.SetElement{Key: end.AsSlice(), IntervalEnd: true})
+		}
+	}
+	return elems, nil
+}
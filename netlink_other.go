@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+func reloadNetlink(context.Context, *Config) error {
+	return errors.New("-reload-mode netlink is only supported on Linux")
+}
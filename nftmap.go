@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// nftVerdicts are the verdicts an -nft-map-value may name; jump and goto
+// take a chain.
+var nftVerdicts = []string{"accept", "drop", "continue", "return", "jump", "goto"}
+
+// nftMapPath returns the file of the nft-map backend, named after
+// -nft-map-name.
+func nftMapPath(cfg *Config) string {
+	return filepath.Join(cfg.OutDir, cfg.NftMapName+".nft")
+}
+
+// nftMapValues returns the map value of every set group: the -nft-map-value
+// of its country, or otherwise its position in -country starting at 1 as a
+// mark. The second result is the nft data type of the values, mark or
+// verdict.
+func (c *Config) nftMapValues() (map[string]string, string, error) {
+	values := map[string]string{}
+	for i, cc := range c.groups() {
+		values[cc] = strconv.Itoa(i + 1)
+	}
+	if c.Invert {
+		values = map[string]string{invertedGroup(c): "1"}
+	}
+	for _, v := range c.NftMapValue {
+		cc, value, ok := strings.Cut(v, "=")
+		cc = strings.ToUpper(strings.TrimSpace(cc))
+		if c.Invert {
+			cc = invertedGroup(c)
+		}
+		if _, known := values[cc]; !ok || !known || strings.TrimSpace(value) == "" {
+			return nil, "", fmt.Errorf("invalid -nft-map-value %q, want CC=value for one of -country %s", v, c.Country)
+		}
+		values[cc] = strings.Join(strings.Fields(value), " ")
+	}
+
+	var marks, verdicts int
+	for _, value := range values {
+		if _, err := strconv.ParseUint(value, 0, 32); err == nil {
+			marks++
+		} else if fields := strings.Fields(value); slices.Contains(nftVerdicts, fields[0]) && (len(fields) == 2) == (fields[0] == "jump" || fields[0] == "goto") {
+			verdicts++
+		} else {
+			return nil, "", fmt.Errorf("invalid -nft-map-value %q, want a mark such as 0x10 or a verdict such as drop or \"jump chain\"", value)
+		}
+	}
+	if marks > 0 && verdicts > 0 {
+		return nil, "", fmt.Errorf("-nft-map-value can't mix marks and verdicts in one map")
+	}
+	if verdicts > 0 {
+		return values, "verdict", nil
+	}
+	return values, "mark", nil
+}
+
+// writeNftMap writes the nft-map backend: for each family a map named
+// -nft-map-name plus 4 or 6 from every prefix of the sets to the value of
+// its country, so a single lookup such as `meta mark set ip saddr map
+// @geo4` or `ip saddr vmap @geo4` covers all countries.
+func writeNftMap(cfg *Config, path string, sets []setSpec) error {
+	style, err := cfg.nftStyle()
+	if err != nil {
+		return err
+	}
+	values, dataType, err := cfg.nftMapValues()
+	if err != nil {
+		return err
+	}
+	in1 := strings.Repeat(" ", style.Indent)
+	in2 := in1 + in1
+
+	return writeLines(path, func(w *bufio.Writer) error {
+		for _, family := range cfg.families() {
+			var elems []string
+			for _, s := range sets {
+				if s.Family != family {
+					continue
+				}
+				for _, item := range s.Elements {
+					elems = append(elems, item+" : "+values[s.Country])
+				}
+			}
+			fmt.Fprintf(w, "map %s%s {\n", cfg.NftMapName, family[len(family)-1:])
+			fmt.Fprintf(w, "%stype %s_addr : %s\n", in1, family, dataType)
+			fmt.Fprintf(w, "%sflags interval\n", in1)
+			if len(elems) > 0 {
+				fmt.Fprintf(w, "%selements = {\n", in1)
+				for i, e := range elems {
+					sep := ","
+					if i == len(elems)-1 && !style.TrailingComma {
+						sep = ""
+					}
+					fmt.Fprintf(w, "%s%s%s\n", in2, e, sep)
+				}
+				fmt.Fprintf(w, "%s}\n", in1)
+			}
+			fmt.Fprintln(w, "}")
+		}
+		return nil
+	})
+}
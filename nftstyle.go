@@ -0,0 +1,125 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// nftCompatJSON maps each -nft-compat-level to the formatting known to work
+// with that range of nftables versions.
+//
+//go:embed nft_compat.json
+var nftCompatJSON []byte
+
+type nftCompatPreset struct {
+	Versions      string `json:"versions"`
+	TrailingComma bool   `json:"trailing_comma"`
+	Indent        int    `json:"indent"`
+}
+
+var nftCompatPresets = func() map[string]nftCompatPreset {
+	var presets map[string]nftCompatPreset
+	if err := json.Unmarshal(nftCompatJSON, &presets); err != nil {
+		panic("nft_compat.json: " + err.Error())
+	}
+	return presets
+}()
+
+func nftCompatLevels() string {
+	levels := make([]string, 0, len(nftCompatPresets))
+	for level := range nftCompatPresets {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	return strings.Join(levels, ", ")
+}
+
+// nftStyle controls the exact text written by writeSetFile.
+type nftStyle struct {
+	TrailingComma bool
+	Indent        int
+	UsageComment  bool
+	// Typeof declares the set with `typeof ip saddr` instead of `type
+	// ipv4_addr`, which needs nftables 0.9.5 or later.
+	Typeof bool
+	// HostOnly drops `flags interval` when every element is a single
+	// address.
+	HostOnly bool
+	// Database and ASNDatabase name the MMDBs of the country and AS sets in
+	// the usage comment.
+	Database, ASNDatabase string
+}
+
+// nftStyle resolves the compat preset and the explicit formatting flags,
+// which take precedence.
+func (c *Config) nftStyle() (nftStyle, error) {
+	preset, ok := nftCompatPresets[c.NftCompatLevel]
+	if !ok {
+		return nftStyle{}, fmt.Errorf("unknown -nft-compat-level %q (want one of %s)", c.NftCompatLevel, nftCompatLevels())
+	}
+	style := nftStyle{
+		TrailingComma: preset.TrailingComma,
+		Indent:        preset.Indent,
+		UsageComment:  c.AddUsageComment,
+		Typeof:        c.NftTypeof,
+		HostOnly:      c.NftHostOnly,
+		Database:      filepath.Base(c.MMDBPath),
+		ASNDatabase:   filepath.Base(c.asnMMDBPath()),
+	}
+	if c.NftTrailingComma.set {
+		style.TrailingComma = c.NftTrailingComma.value
+	}
+	if c.NftIndentSize >= 0 {
+		style.Indent = c.NftIndentSize
+	}
+	return style, nil
+}
+
+// minTypeofVersion is the first nftables release supporting typeof in set
+// declarations.
+var minTypeofVersion = [3]int{0, 9, 5}
+
+// typeDecl returns the element type line of a set declaration.
+func (s nftStyle) typeDecl(addrType string) string {
+	if !s.Typeof {
+		return "type " + addrType
+	}
+	if addrType == "ipv6_addr" {
+		return "typeof ip6 saddr"
+	}
+	return "typeof ip saddr"
+}
+
+// usageComment explains how to use the generated set file of s in an nftables
+// configuration. It holds nothing that changes between runs, so an update
+// that yields the same prefixes leaves the file as it was.
+func usageComment(path string, s setSpec, style nftStyle) string {
+	match := strings.TrimPrefix(nftStyle{Typeof: true}.typeDecl(s.AddrType), "typeof ")
+	summary := fmt.Sprintf("Set %s: %s prefixes", s.Name, familyLabel(strings.TrimSuffix(s.AddrType, "_addr")))
+	database := style.Database
+	if len(s.Country) > 2 && strings.HasPrefix(s.Country, "AS") {
+		database = style.ASNDatabase
+	}
+	if database != "" && s.Country != "" {
+		summary += " generated from " + database
+	}
+	lines := []string{
+		summary + ".",
+		"",
+		"Include this file inside a table of your nftables configuration:",
+		fmt.Sprintf("    include \"%s\"", path),
+		"and reference the set in a rule, for example:",
+		fmt.Sprintf("    %s @%s drop", match, s.Name),
+		"",
+		"Do not edit, the file is overwritten on every update.",
+	}
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(strings.TrimRight("# "+l, " ") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// runSummary describes one update run for the notifications.
+type runSummary struct {
+	Host     string
+	Start    time.Time
+	Duration time.Duration
+	// Tag is the release the MMDB came from, "" for -mmdb-url and
+	// -ftp-url.
+	Tag string
+	// Changed is set when the downloaded MMDB differs from the installed
+	// one.
+	Changed bool
+	// Counts and Previous are the element counts of the sets after and
+	// before the run.
+	Counts   map[string]int
+	Previous map[string]int
+	// Reload is what became of the reload: "ok", "skipped: <reason>" or
+	// "failed: <error>". Empty when the run failed earlier.
+	Reload string
+	Err    error
+}
+
+func newRunSummary() *runSummary {
+	host, _ := os.Hostname()
+	return &runSummary{Host: host, Start: time.Now(), Counts: map[string]int{}, Previous: map[string]int{}}
+}
+
+// notifyModes are the accepted -notify-on values.
+var notifyModes = []string{"always", "change", "failure"}
+
+// wanted reports whether s is worth a notification under -notify-on.
+func (s *runSummary) wanted(cfg *Config) bool {
+	switch cfg.NotifyOn {
+	case "failure":
+		return s.Err != nil
+	case "change":
+		return s.Err != nil || s.Changed
+	}
+	return true
+}
+
+// text renders s as a short multi-line message.
+func (s *runSummary) text() string {
+	var b strings.Builder
+	if s.Err != nil {
+		fmt.Fprintf(&b, "auto-update-mmdb on %s: update FAILED\n", s.Host)
+	} else {
+		fmt.Fprintf(&b, "auto-update-mmdb on %s: update succeeded\n", s.Host)
+	}
+	if s.Tag != "" {
+		fmt.Fprintf(&b, "Tag: %s\n", s.Tag)
+	}
+	if s.Err == nil && !s.Changed {
+		b.WriteString("The MMDB did not change.\n")
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Counts)) {
+		n := s.Counts[name]
+		if prev, ok := s.Previous[name]; ok && prev != n {
+			fmt.Fprintf(&b, "%s: %d ranges (%+d)\n", name, n, n-prev)
+		} else {
+			fmt.Fprintf(&b, "%s: %d ranges\n", name, n)
+		}
+	}
+	if s.Reload != "" {
+		fmt.Fprintf(&b, "Reload: %s\n", s.Reload)
+	}
+	if s.Err != nil {
+		fmt.Fprintf(&b, "Error: %v\n", s.Err)
+	}
+	fmt.Fprintf(&b, "Duration: %s", s.Duration.Round(time.Millisecond))
+	return b.String()
+}
+
+// notify sends s to every configured notification channel. Failures are
+// only logged.
+func notify(cfg *Config, s *runSummary) {
+	if !s.wanted(cfg) {
+		return
+	}
+	if cfg.TelegramBotToken != "" {
+		if err := sendTelegram(cfg, s.text()); err != nil {
+			logWarn(fmt.Sprintf("Telegram notification failed: %v", err))
+		}
+	}
+	if cfg.SlackWebhookURL != "" {
+		if err := sendSlack(cfg.SlackWebhookURL, s.text()); err != nil {
+			logWarn(fmt.Sprintf("Slack notification failed: %v", err))
+		}
+	}
+	if cfg.DiscordWebhookURL != "" {
+		if err := sendDiscord(cfg.DiscordWebhookURL, s.text()); err != nil {
+			logWarn(fmt.Sprintf("Discord notification failed: %v", err))
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if err := sendWebhook(cfg, s); err != nil {
+			logWarn(fmt.Sprintf("Webhook failed: %v", err))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// otelExportInterval is the period of the background OTLP export. Updates
+// additionally flush right away, see flushMetrics.
+const otelExportInterval = time.Minute
+
+// otelProvider is set when -otel-metrics-endpoint is configured.
+var otelProvider *sdkmetric.MeterProvider
+
+// startOTel sets up the OTLP metrics export to -otel-metrics-endpoint. It is
+// a no-op without an endpoint.
+func startOTel(cfg *Config) error {
+	if cfg.OTelMetricsEndpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.OTelMetricsEndpoint)
+	if err != nil {
+		return err
+	}
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(u.Host)}
+	if u.Scheme == "grpc" {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	exporter, err := otlpmetricgrpc.New(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("otlp exporter: %w", err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(
+		sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(otelExportInterval)),
+	))
+	if err := registerOTelMetrics(provider.Meter("github.com/missuo/auto-update-mmdb")); err != nil {
+		provider.Shutdown(context.Background())
+		return err
+	}
+	otelProvider = provider
+	logInfo("Exporting OTLP metrics to " + u.Host)
+	return nil
+}
+
+// registerOTelMetrics exposes stats as asynchronous instruments.
+func registerOTelMetrics(meter metric.Meter) error {
+	updates, err := meter.Int64ObservableCounter("mmdb_updates_total", metric.WithDescription("Update runs, successful or not."))
+	if err != nil {
+		return err
+	}
+	failures, err := meter.Int64ObservableCounter("mmdb_update_failures_total", metric.WithDescription("Update runs that failed."))
+	if err != nil {
+		return err
+	}
+	lastSuccess, err := meter.Float64ObservableGauge("mmdb_last_success_timestamp_seconds", metric.WithDescription("Unix time of the last successful update."))
+	if err != nil {
+		return err
+	}
+	duration, err := meter.Float64ObservableGauge("mmdb_update_duration_seconds", metric.WithDescription("Duration of the last update run."), metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	download, err := meter.Float64ObservableGauge("mmdb_download_duration_seconds", metric.WithDescription("Duration of the last MMDB download."), metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	elements, err := meter.Int64ObservableGauge("mmdb_set_elements", metric.WithDescription("Elements written to each set."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		s := stats.snapshot()
+		o.ObserveInt64(updates, s.Updates)
+		o.ObserveInt64(failures, s.Failures)
+		if !s.LastSuccess.IsZero() {
+			o.ObserveFloat64(lastSuccess, float64(s.LastSuccess.Unix()))
+		}
+		o.ObserveFloat64(duration, s.LastDuration.Seconds())
+		o.ObserveFloat64(download, s.Download.Seconds())
+		for name, n := range s.SetElements {
+			o.ObserveInt64(elements, int64(n), metric.WithAttributes(attribute.String("set", name)))
+		}
+		return nil
+	}, updates, failures, lastSuccess, duration, download, elements)
+	return err
+}
+
+// flushMetrics pushes the current metrics to the OTLP endpoint, if any.
+func flushMetrics() {
+	if otelProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := otelProvider.ForceFlush(ctx); err != nil {
+		logWarn("OTLP metrics export failed: " + err.Error())
+	}
+}
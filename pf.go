@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// pfTable is the name of the pf table holding the sets of country, e.g. cn.
+func pfTable(country string) string {
+	return strings.ToLower(country)
+}
+
+// pfSnippetPath returns the pf.conf snippet written next to the table file
+// path with -pf-snippet.
+func pfSnippetPath(path string) string {
+	return path + ".conf"
+}
+
+// writePF writes a pf table file with one prefix per line, IPv4 and IPv6
+// alike, and with -pf-snippet a pf.conf fragment declaring the table.
+func writePF(cfg *Config, path string, sets []setSpec) error {
+	err := writeLines(path, func(w *bufio.Writer) error {
+		for _, s := range sets {
+			for _, item := range s.Elements {
+				fmt.Fprintln(w, item)
+			}
+		}
+		return nil
+	})
+	if err != nil || !cfg.PFSnippet {
+		return err
+	}
+	snippet := fmt.Sprintf("table <%s> persist file \"%s\"\n", pfTable(sets[0].Country), path)
+	return writeFileAtomic(pfSnippetPath(path), []byte(snippet))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pingTimeout bounds each -ping-url request.
+const pingTimeout = 10 * time.Second
+
+// pingURL reports the outcome of an update to a dead man's switch such as
+// healthchecks.io: a GET of -ping-url on success, and on failure a POST of
+// the error message to -ping-url with /fail appended. A failed ping is only
+// logged.
+func pingURL(cfg *Config, updateErr error) {
+	if cfg.PingURL == "" {
+		return
+	}
+	url := cfg.PingURL
+	method := http.MethodGet
+	body := ""
+	if updateErr != nil {
+		url = strings.TrimSuffix(url, "/") + "/fail"
+		method = http.MethodPost
+		body = updateErr.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		logWarn(fmt.Sprintf("Ping failed: %v", err))
+		return
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logWarn(fmt.Sprintf("Ping failed: %v", err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logWarn(fmt.Sprintf("Ping to %s failed: %s", redactURL(url), resp.Status))
+		return
+	}
+	logDebug("Pinged " + redactURL(url))
+}
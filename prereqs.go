@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var nftVersionRe = regexp.MustCompile(`v(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// runCheckPrereqs verifies that the host can run an update with the given
+// configuration.
+func runCheckPrereqs(args []string) error {
+	cfg, _, err := loadConfig("check-prereqs", args, (*Config).bindFlags)
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	var problems int
+	check := func(ok bool, msg string) {
+		if ok {
+			logInfo("OK   " + msg)
+			return
+		}
+		logWarn("FAIL " + msg)
+		problems++
+	}
+
+	// -reload-mode netlink doesn't run nft at all.
+	if cfg.ReloadMode != "netlink" {
+		version, err := nftVersion()
+		check(err == nil, fmt.Sprintf("nft is installed (%s)", versionString(version, err)))
+		if cfg.NftTypeof && err == nil {
+			check(versionAtLeast(version, minTypeofVersion), fmt.Sprintf("nft %d.%d.%d or later for -nft-typeof", minTypeofVersion[0], minTypeofVersion[1], minTypeofVersion[2]))
+		}
+	}
+
+	for _, reload := range reloadCommands(cfg) {
+		_, err := exec.LookPath(reload[0])
+		check(err == nil, fmt.Sprintf("reload command %q is available", reload[0]))
+	}
+
+	for _, dir := range []string{filepath.Dir(cfg.MMDBPath), cfg.OutDir, filepath.Dir(cfg.StateFile)} {
+		check(dirWritable(dir), dir+" is writable")
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d prerequisite checks failed", problems)
+	}
+	return nil
+}
+
+// nftVersion returns the version reported by `nft --version`.
+func nftVersion() ([3]int, error) {
+	var v [3]int
+	out, err := exec.Command("nft", "--version").Output()
+	if err != nil {
+		return v, err
+	}
+	m := nftVersionRe.FindStringSubmatch(string(out))
+	if m == nil {
+		return v, fmt.Errorf("unrecognized version %q", strings.TrimSpace(string(out)))
+	}
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, nil
+}
+
+func versionString(v [3]int, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("v%d.%d.%d", v[0], v[1], v[2])
+}
+
+func versionAtLeast(v, min [3]int) bool {
+	for i := range v {
+		if v[i] != min[i] {
+			return v[i] > min[i]
+		}
+	}
+	return true
+}
+
+// dirWritable reports whether a file can be created in dir, creating dir
+// itself if needed.
+func dirWritable(dir string) bool {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false
+	}
+	f, err := os.CreateTemp(dir, ".check-*")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressBarWidth is the number of cells of the terminal progress bar.
+const progressBarWidth = 30
+
+// progressRedraw limits how often the terminal progress bar is redrawn.
+const progressRedraw = 200 * time.Millisecond
+
+// progress reports a download as its body is written to it: on a terminal
+// as a progress bar redrawn in place, otherwise as a log line every
+// interval.
+type progress struct {
+	// done and total count from the start of the file, including bytes
+	// resumed from an earlier attempt; total is -1 when unknown.
+	done, total int64
+	offset      int64
+	interval    time.Duration
+	bar         bool
+	start, last time.Time
+}
+
+func newProgress(offset, total int64, interval time.Duration) *progress {
+	now := time.Now()
+	return &progress{
+		done: offset, total: total, offset: offset, interval: interval,
+		// The bar would garble the output when logs are quieter than info.
+		bar:   isTerminal(os.Stdout) && logLevel <= levelInfo,
+		start: now, last: now,
+	}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	now := time.Now()
+	switch {
+	case p.bar && now.Sub(p.last) >= progressRedraw:
+		fmt.Print("\r" + p.line(now, true) + "\033[K")
+		p.last = now
+	case !p.bar && now.Sub(p.last) >= p.interval:
+		logInfo("Downloaded " + p.line(now, false))
+		p.last = now
+	}
+	return len(b), nil
+}
+
+// clear removes the progress bar.
+func (p *progress) clear() {
+	if p.bar {
+		fmt.Print("\r\033[K")
+	}
+}
+
+// finish clears the progress bar and logs the transfer statistics.
+func (p *progress) finish() {
+	p.clear()
+	elapsed := time.Since(p.start)
+	logInfo(fmt.Sprintf("Downloaded %s in %s (%s/s).", formatBytes(p.done-p.offset), elapsed.Round(time.Millisecond), formatBytes(p.rate(elapsed))))
+}
+
+// rate returns the bytes per second transferred by this attempt.
+func (p *progress) rate(elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(p.done-p.offset) / elapsed.Seconds())
+}
+
+// line describes the progress, such as "1.2 MiB of 3.5 MiB (34%), 850 KiB/s",
+// preceded by a bar when bar is set.
+func (p *progress) line(now time.Time, bar bool) string {
+	var b strings.Builder
+	if p.total > 0 {
+		frac := min(float64(p.done)/float64(p.total), 1)
+		if bar {
+			cells := int(frac * progressBarWidth)
+			fmt.Fprintf(&b, "[%s%s] ", strings.Repeat("=", cells), strings.Repeat(" ", progressBarWidth-cells))
+		}
+		fmt.Fprintf(&b, "%s of %s (%.0f%%)", formatBytes(p.done), formatBytes(p.total), frac*100)
+	} else {
+		b.WriteString(formatBytes(p.done))
+	}
+	fmt.Fprintf(&b, ", %s/s", formatBytes(p.rate(now.Sub(p.start))))
+	return b.String()
+}
+
+// formatBytes renders n in binary units, such as "3.5 MiB".
+func formatBytes(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	f := float64(n) / (1 << 10)
+	i := 0
+	for f >= 1<<10 && i < len(units)-1 {
+		f /= 1 << 10
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// servePrometheus writes stats in the Prometheus text exposition format,
+// using the names of the OTLP metrics.
+func servePrometheus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheus(w, stats.snapshot())
+}
+
+func writePrometheus(w io.Writer, s metricsSnapshot) {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("mmdb_updates_total", "counter", "Update runs, successful or not.")
+	fmt.Fprintf(w, "mmdb_updates_total %d\n", s.Updates)
+	metric("mmdb_update_failures_total", "counter", "Update runs that failed.")
+	fmt.Fprintf(w, "mmdb_update_failures_total %d\n", s.Failures)
+	if !s.LastSuccess.IsZero() {
+		metric("mmdb_last_success_timestamp_seconds", "gauge", "Unix time of the last successful update.")
+		fmt.Fprintf(w, "mmdb_last_success_timestamp_seconds %d\n", s.LastSuccess.Unix())
+	}
+	metric("mmdb_update_duration_seconds", "gauge", "Duration of the last update run.")
+	fmt.Fprintf(w, "mmdb_update_duration_seconds %g\n", s.LastDuration.Seconds())
+	metric("mmdb_download_duration_seconds", "gauge", "Duration of the last MMDB download.")
+	fmt.Fprintf(w, "mmdb_download_duration_seconds %g\n", s.Download.Seconds())
+	if s.Tag != "" {
+		metric("mmdb_release_info", "gauge", "Release tag of the last downloaded MMDB.")
+		fmt.Fprintf(w, "mmdb_release_info{tag=%s} 1\n", strconv.Quote(s.Tag))
+	}
+
+	metric("mmdb_set_elements", "gauge", "Elements written to each set.")
+	for _, name := range slices.Sorted(maps.Keys(s.SetElements)) {
+		fmt.Fprintf(w, "mmdb_set_elements{set=%s} %d\n", strconv.Quote(name), s.SetElements[name])
+	}
+}
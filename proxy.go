@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// proxySchemes are the accepted -proxy URL schemes.
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// ftpDial connects the FTP control and data connections, through the SOCKS5
+// proxy when one applies. It is installed by Config.apply.
+var ftpDial = (&net.Dialer{}).DialContext
+
+// httpProxy returns the proxy selection of the HTTP client: -proxy for
+// every request when set, otherwise HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// from the environment, with ALL_PROXY as fallback when neither of the
+// first two is set.
+func httpProxy(cfg *Config) func(*http.Request) (*url.URL, error) {
+	if cfg.Proxy != "" {
+		u, _ := url.Parse(cfg.Proxy)
+		return http.ProxyURL(u)
+	}
+	env := httpproxy.FromEnvironment()
+	if env.HTTPProxy == "" && env.HTTPSProxy == "" {
+		all := os.Getenv("ALL_PROXY")
+		if all == "" {
+			all = os.Getenv("all_proxy")
+		}
+		env.HTTPProxy, env.HTTPSProxy = all, all
+	}
+	proxyFor := env.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFor(req.URL)
+	}
+}
+
+// newFTPDial returns the dial function of FTP downloads: through a socks5
+// -proxy when set, otherwise through ALL_PROXY subject to NO_PROXY. HTTP
+// proxies can't carry FTP. Connecting gives up after -connect-timeout.
+func newFTPDial(cfg *Config) func(ctx context.Context, network, address string) (net.Conn, error) {
+	direct := newDialer(cfg)
+	d := proxy.FromEnvironmentUsing(direct)
+	if cfg.Proxy != "" {
+		u, _ := url.Parse(cfg.Proxy)
+		if pd, err := proxy.FromURL(u, direct); err == nil {
+			d = pd
+		} else {
+			d = direct
+		}
+	}
+	if cd, ok := d.(proxy.ContextDialer); ok {
+		return cd.DialContext
+	}
+	return func(_ context.Context, network, address string) (net.Conn, error) {
+		return d.Dial(network, address)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// countrySpace is the IPv4 coverage of one country.
+type countrySpace struct {
+	Code      string
+	Name      string
+	Addresses uint64
+	Blocks    int
+	Largest   int // shortest prefix length seen
+}
+
+// runRank prints countries ordered by the number of IPv4 addresses the MMDB
+// assigns to them.
+func runRank(args []string) error {
+	var top int
+	cfg, _, err := loadConfig("rank", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.IntVar(&top, "top", 0, "only list the first `n` countries (0 lists all)")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	db, err := maxminddb.Open(cfg.MMDBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ranking, total, err := rankCountries(db, cfg.source(), cfg.Lang)
+	if err != nil {
+		return err
+	}
+	if top > 0 && top < len(ranking) {
+		ranking = ranking[:top]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tCOUNTRY\tADDRESSES\tSHARE\tBLOCKS\tAVG BLOCK\tLARGEST")
+	for i, c := range ranking {
+		fmt.Fprintf(w, "%d\t%s %s\t%d\t%.3f%%\t%d\t%d\t/%d\n",
+			i+1, c.Code, c.Name, c.Addresses, share(c.Addresses), c.Blocks, c.Addresses/uint64(c.Blocks), c.Largest)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nTotal: %d IPv4 addresses with a country, %.2f%% of the IPv4 address space\n", total, share(total))
+	return nil
+}
+
+// rankCountries sums the IPv4 addresses of every country in db, largest
+// first, and returns the total over all countries.
+func rankCountries(db *maxminddb.Reader, src geoSource, lang string) ([]countrySpace, uint64, error) {
+	byCode := map[string]*countrySpace{}
+	var total uint64
+
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		network, rec, err := nextRecord(src, networks)
+		if err != nil {
+			return nil, 0, err
+		}
+		ones, size := network.Mask.Size()
+		if size != 32 || rec.Country.ISOCode == "" {
+			continue
+		}
+
+		c, ok := byCode[rec.Country.ISOCode]
+		if !ok {
+			c = &countrySpace{Code: rec.Country.ISOCode, Name: localizedName(rec.Country.Names, lang), Largest: 32}
+			byCode[rec.Country.ISOCode] = c
+		}
+		n := uint64(1) << (32 - ones)
+		c.Addresses += n
+		c.Blocks++
+		c.Largest = min(c.Largest, ones)
+		total += n
+	}
+	if err := networks.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	ranking := make([]countrySpace, 0, len(byCode))
+	for _, c := range byCode {
+		ranking = append(ranking, *c)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].Addresses != ranking[j].Addresses {
+			return ranking[i].Addresses > ranking[j].Addresses
+		}
+		return ranking[i].Code < ranking[j].Code
+	})
+	return ranking, total, nil
+}
+
+// share is n as a percentage of the IPv4 address space.
+func share(n uint64) float64 {
+	return float64(n) / float64(uint64(1)<<32) * 100
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// githubPageSize is the largest page the GitHub releases API returns.
+const githubPageSize = 100
+
+// runListReleases prints the most recent MMDB releases.
+func runListReleases(args []string) error {
+	var count int
+	var asJSON bool
+	cfg, _, err := loadConfig("list-releases", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindCommonFlags(fs)
+		fs.IntVar(&count, "count", 10, "number of releases to list")
+		fs.BoolVar(&asJSON, "json", false, "print the releases as JSON")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+	if count < 1 {
+		return fmt.Errorf("-count must be at least 1")
+	}
+
+	releases, err := fetchReleases(cfg.GitHubRepo, count)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(releases)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tPUBLISHED\tASSETS")
+	for _, r := range releases {
+		names := make([]string, len(r.Assets))
+		for i, a := range r.Assets {
+			names[i] = a.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.TagName, r.PublishedAt.Format(time.DateTime), strings.Join(names, ", "))
+	}
+	return w.Flush()
+}
+
+// fetchReleases returns up to count releases, newest first, following the
+// API pagination as needed.
+func fetchReleases(repo string, count int) ([]GitHubRelease, error) {
+	perPage := min(count, githubPageSize)
+	var releases []GitHubRelease
+	for page := 1; len(releases) < count; page++ {
+		var batch []GitHubRelease
+		if err := githubGet(context.Background(), fmt.Sprintf("%s?per_page=%d&page=%d", releasesURL(repo), perPage, page), nil, &batch); err != nil {
+			return nil, err
+		}
+		releases = append(releases, batch...)
+		if len(batch) < perPage {
+			break
+		}
+	}
+	if len(releases) > count {
+		releases = releases[:count]
+	}
+	return releases, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultNftablesConf = "/etc/nftables.conf"
+
+// netnsPrefix returns the command prefix that runs a program inside the
+// configured network namespace. A value containing a slash is treated as a
+// namespace file and entered with nsenter, anything else is a named
+// namespace managed by `ip netns`.
+func netnsPrefix(netns string) []string {
+	if netns == "" {
+		return nil
+	}
+	if strings.Contains(netns, "/") {
+		return []string{"nsenter", "--net=" + netns, "--"}
+	}
+	return []string{"ip", "netns", "exec", netns}
+}
+
+// netnsFile returns the namespace file of -netns: a path as given, or the
+// file `ip netns` keeps for a named namespace.
+func netnsFile(netns string) string {
+	if strings.Contains(netns, "/") {
+		return netns
+	}
+	return "/run/netns/" + netns
+}
+
+// reloadCommand returns the command that applies the generated sets.
+func reloadCommand(cfg *Config) []string {
+	if cfg.ReloadCmd != "" {
+		return append(netnsPrefix(cfg.Netns), strings.Fields(cfg.ReloadCmd)...)
+	}
+	if cfg.ReloadMode == "sets" {
+		return append(netnsPrefix(cfg.Netns), "nft", "-f", cfg.setsScriptPath())
+	}
+	if cfg.Netns == "" && cfg.ReloadMode == "reload" {
+		return []string{"systemctl", "reload", "nftables"}
+	}
+	if cfg.Netns == "" {
+		return []string{"systemctl", "restart", "nftables"}
+	}
+	// systemd units don't run inside the target namespace, so load the
+	// ruleset there directly.
+	return append(netnsPrefix(cfg.Netns), "nft", "-f", cfg.NftablesConf)
+}
+
+// checkNetns makes sure nft can be run inside the configured namespace before
+// any work is done.
+func checkNetns(cfg *Config) error {
+	if cfg.ReloadMode == "netlink" {
+		if _, err := os.Stat(netnsFile(cfg.Netns)); err != nil {
+			return fmt.Errorf("network namespace %s is not usable: %w", cfg.Netns, err)
+		}
+		return nil
+	}
+	args := append(netnsPrefix(cfg.Netns), "nft", "list", "ruleset")
+	if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("network namespace %s is not usable: %v: %s", cfg.Netns, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reloadCommands lists every command reloadAll runs: -reload-cmd when given,
+// otherwise the nftables reload for the nft backend, unless it goes over
+// netlink, followed by the reload commands of the other backends. The
+// -restart-service restarts come last either way.
+func reloadCommands(cfg *Config) [][]string {
+	var cmds [][]string
+	if cfg.ReloadCmd != "" {
+		cmds = append(cmds, reloadCommand(cfg))
+	} else {
+		if cfg.writesNftables() && cfg.ReloadMode != "netlink" {
+			cmds = append(cmds, reloadCommand(cfg))
+		}
+		for _, name := range cfg.backends() {
+			if cmd := backends[name].Reload; cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
+	for _, svc := range cfg.RestartServices {
+		cmds = append(cmds, []string{"systemctl", "restart", svc})
+	}
+	return cmds
+}
+
+// writesNftables reports whether a backend writes files nftables loads, so
+// that it is reloaded after an update.
+func (c *Config) writesNftables() bool {
+	return c.hasBackend("nft") || c.hasBackend("nft-map")
+}
+
+// reloadNames names the steps of reloadAll for the log: "netlink" and the
+// reloadCommands.
+func reloadNames(cfg *Config) []string {
+	var names []string
+	if cfg.ReloadMode == "netlink" && cfg.hasBackend("nft") {
+		names = append(names, "netlink")
+	}
+	for _, cmd := range reloadCommands(cfg) {
+		names = append(names, strings.Join(cmd, " "))
+	}
+	return names
+}
+
+// reloadAll applies the generated files over netlink with -reload-mode
+// netlink and by running reloadCommands. restore, when not nil, is passed on
+// to reloadNftables. Only a timeout of the nftables reload wraps
+// errReloadTimeout; the other commands report theirs as plain errors.
+func reloadAll(ctx context.Context, cfg *Config, restore func() error) error {
+	cmds := reloadCommands(cfg)
+	if cfg.ReloadMode == "netlink" && cfg.hasBackend("nft") {
+		if err := reloadNetlink(ctx, cfg); err != nil {
+			return err
+		}
+	} else if cfg.ReloadCmd != "" || cfg.writesNftables() {
+		if err := reloadNftables(ctx, cfg, restore); err != nil {
+			return err
+		}
+		cmds = cmds[1:]
+	}
+	for _, cmd := range cmds {
+		if err := runReloadCommand(ctx, cmd, cfg.TimeoutNft); err != nil {
+			return unwrapReloadTimeout(err)
+		}
+	}
+	return nil
+}
+
+// errReloadTimeout marks a reload that was killed by -timeout-nft.
+var errReloadTimeout = errors.New("nftables reload timed out")
+
+// reloadNftables runs the reload command, writing the script of -reload-mode
+// sets first. With -nft-check the file nftables is about to load goes
+// through checkRuleset before. When the reload exceeds -timeout-nft it is
+// killed and that file is loaded with nft -f directly instead; when restore
+// is not nil it first puts the previous files back, so the fallback loads
+// those. The returned error wraps errReloadTimeout either way.
+func reloadNftables(ctx context.Context, cfg *Config, restore func() error) error {
+	loaded := cfg.NftablesConf
+	if cfg.ReloadMode == "sets" {
+		if err := writeSetsScript(cfg); err != nil {
+			return err
+		}
+		loaded = cfg.setsScriptPath()
+	}
+	// What a -reload-cmd loads is unknown.
+	if cfg.NftCheck && cfg.ReloadCmd == "" {
+		if err := checkRuleset(ctx, cfg, loaded); err != nil {
+			return err
+		}
+	}
+	args := simulatedReloadCommand(reloadCommand(cfg))
+	err := runReloadCommand(ctx, args, cfg.TimeoutNft)
+	if !errors.Is(err, errReloadTimeout) {
+		return err
+	}
+	what := "the ruleset"
+	if restore != nil {
+		logWarn(err.Error() + ", restoring the previous files")
+		if rerr := restore(); rerr != nil {
+			return fmt.Errorf("%w, restoring the backup failed: %v", err, rerr)
+		}
+		if cfg.ReloadMode == "sets" {
+			if rerr := writeSetsScript(cfg); rerr != nil {
+				return fmt.Errorf("%w, the previous files were restored but the sets script failed: %v", err, rerr)
+			}
+		}
+		what = "the restored ruleset"
+	}
+	fallback := append(netnsPrefix(cfg.Netns), "nft", "-f", loaded)
+	if slices.Equal(args, fallback) {
+		if restore != nil {
+			return fmt.Errorf("%w, the previous files were restored but not loaded", err)
+		}
+		return err
+	}
+	logWarn(err.Error() + ", falling back to " + strings.Join(fallback, " "))
+	if ferr := runReloadCommand(ctx, fallback, cfg.TimeoutNft); ferr != nil {
+		return fmt.Errorf("%w, loading %s with the fallback command failed: %v", err, what, unwrapReloadTimeout(ferr))
+	}
+	return fmt.Errorf("%w, %s was loaded with the fallback command", err, what)
+}
+
+// unwrapReloadTimeout turns a timeout of a command other than the nftables
+// reload into a plain error, so it doesn't get the handling of one.
+func unwrapReloadTimeout(err error) error {
+	if errors.Is(err, errReloadTimeout) {
+		return errors.New(err.Error())
+	}
+	return err
+}
+
+// checkRuleset runs path through nft -c, which parses and evaluates it
+// against the running ruleset without applying anything.
+func checkRuleset(ctx context.Context, cfg *Config, path string) error {
+	args := append(netnsPrefix(cfg.Netns), "nft", "-c", "-f", path)
+	if err := runReloadCommand(ctx, args, cfg.TimeoutNft); err != nil {
+		return fmt.Errorf("%s failed the nft -c check, nftables was not reloaded: %w", path, unwrapReloadTimeout(err))
+	}
+	logDebug(path + " passed the nft -c check")
+	return nil
+}
+
+// runReloadCommand runs args, killing it when ctx is done or after timeout
+// unless timeout is zero.
+func runReloadCommand(ctx context.Context, args []string, timeout time.Duration) error {
+	cmdCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	logDebug("Running " + strings.Join(args, " "))
+	out, err := exec.CommandContext(cmdCtx, args[0], args[1:]...).CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", args[0], ctx.Err())
+	}
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %s killed after %s", errReloadTimeout, args[0], timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v, output: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
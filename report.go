@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// statusReport describes the installed database and generated files.
+type statusReport struct {
+	Tag          string       `json:"tag,omitempty"`
+	MMDBPath     string       `json:"mmdb_path"`
+	DatabaseType string       `json:"database_type,omitempty"`
+	BuildDate    string       `json:"build_date,omitempty"`
+	Files        []fileReport `json:"files"`
+}
+
+type fileReport struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Elements int    `json:"elements"`
+	Error    string `json:"error,omitempty"`
+}
+
+func buildReport(cfg *Config, tag string) statusReport {
+	r := statusReport{Tag: tag, MMDBPath: cfg.MMDBPath}
+	if db, err := maxminddb.Open(cfg.MMDBPath); err == nil {
+		r.DatabaseType = db.Metadata.DatabaseType
+		r.BuildDate = time.Unix(int64(db.Metadata.BuildEpoch), 0).UTC().Format(time.RFC3339)
+		db.Close()
+	}
+
+	for _, s := range generatedSets(cfg) {
+		f := fileReport{Path: s.Path}
+		if fi, err := os.Stat(s.Path); err != nil {
+			f.Error = err.Error()
+		} else {
+			f.Size = fi.Size()
+			elems, err := readSetFile(s.Path)
+			if err != nil {
+				f.Error = err.Error()
+			}
+			f.Elements = len(elems)
+		}
+		r.Files = append(r.Files, f)
+	}
+	return r
+}
+
+// printReport writes r to stdout, as JSON when asJSON is set.
+func printReport(r statusReport, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+		return
+	}
+
+	tag := r.Tag
+	if tag == "" {
+		tag = "(unknown)"
+	}
+	logInfo("Current tag: " + tag)
+	logInfo(fmt.Sprintf("MMDB: %s (%s, built %s)", r.MMDBPath, r.DatabaseType, r.BuildDate))
+	for _, f := range r.Files {
+		if f.Error != "" {
+			logInfo(fmt.Sprintf("- %s: %s", f.Path, f.Error))
+			continue
+		}
+		logInfo(fmt.Sprintf("- %s (%d bytes, %d elements)", f.Path, f.Size, f.Elements))
+	}
+}
+
+// sameContent reports whether the files at a and b have identical content.
+func sameContent(a, b string) bool {
+	ha, err := fileSHA256(a)
+	if err != nil {
+		return false
+	}
+	hb, err := fileSHA256(b)
+	return err == nil && ha == hb
+}
+
+func fileSHA256(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// partial describes the raw bytes of an interrupted download kept in
+// <path>.part, so that the next attempt can ask for the rest with a Range
+// request instead of starting over.
+type partial struct {
+	URL string `json:"url"`
+	// Validator is the strong ETag or the Last-Modified date of the
+	// response, sent as If-Range: should the file have changed in the
+	// meantime the server answers with all of it.
+	Validator string `json:"validator"`
+	// Size is the total size the server announced, or -1.
+	Size int64 `json:"size"`
+}
+
+func partPath(path string) string {
+	return path + ".part"
+}
+
+func partInfoPath(path string) string {
+	return partPath(path) + ".json"
+}
+
+// loadPartial returns the partial download of url into path and how many
+// bytes of it are on disk. Leftovers of another URL are discarded.
+func loadPartial(path, url string) (*partial, int64) {
+	var p partial
+	data, err := os.ReadFile(partInfoPath(path))
+	if err == nil && json.Unmarshal(data, &p) == nil && p.URL == url && p.Validator != "" {
+		if fi, err := os.Stat(partPath(path)); err == nil && fi.Size() > 0 {
+			return &p, fi.Size()
+		}
+	}
+	removePartial(path)
+	return nil, 0
+}
+
+func savePartial(path string, p *partial) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partInfoPath(path), data, 0644)
+}
+
+func removePartial(path string) {
+	os.Remove(partPath(path))
+	os.Remove(partInfoPath(path))
+}
+
+// resumeValidator returns the If-Range value for resp: its ETag unless that
+// is weak, which If-Range doesn't accept, else its Last-Modified date.
+// Transparently decompressed responses can't be resumed, the offsets on
+// disk don't match the server's.
+func resumeValidator(resp *http.Response) string {
+	if resp.Uncompressed {
+		return ""
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// parseContentRange parses a "bytes first-last/total" Content-Range header.
+// total is -1 when the server sent "*".
+func parseContentRange(s string) (first, total int64, err error) {
+	rng, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	rng, size, ok := strings.Cut(rng, "/")
+	firstStr, _, ok2 := strings.Cut(rng, "-")
+	if !ok || !ok2 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	if first, err = strconv.ParseInt(firstStr, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	if size == "*" {
+		return first, -1, nil
+	}
+	if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return first, total, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in           string
+		first, total int64
+		wantErr      bool
+	}{
+		{in: "bytes 100-199/200", first: 100, total: 200},
+		{in: "bytes 0-0/1", first: 0, total: 1},
+		{in: "bytes 4096-8191/*", first: 4096, total: -1},
+		{in: "", wantErr: true},
+		{in: "bytes */200", wantErr: true},
+		{in: "bytes 100-199", wantErr: true},
+		{in: "items 100-199/200", wantErr: true},
+		{in: "bytes x-199/200", wantErr: true},
+		{in: "bytes 100-199/y", wantErr: true},
+	}
+	for _, tt := range tests {
+		first, total, err := parseContentRange(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseContentRange(%q) = %d, %d, want an error", tt.in, first, total)
+			}
+			continue
+		}
+		if err != nil || first != tt.first || total != tt.total {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d", tt.in, first, total, err, tt.first, tt.total)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"time"
+)
+
+// statusError is an HTTP response with an unexpected status.
+type statusError struct {
+	msg  string
+	code int
+	// status is the status line, e.g. "503 Service Unavailable".
+	status string
+}
+
+func (e *statusError) Error() string {
+	return e.msg + ": " + e.status
+}
+
+// temporaryError marks a failure as worth retrying when its type doesn't
+// tell.
+type temporaryError struct{ error }
+
+func (e temporaryError) Unwrap() error { return e.error }
+
+// retryable reports whether err may go away on its own: network errors,
+// truncated bodies, HTTP 408, 429 and 5xx responses and FTP 4xx replies.
+// A rate-limited GitHub API, other HTTP errors and verification failures
+// are final.
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusRequestTimeout || status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// url.Error claims to be a net.Error, judge what it wraps.
+		return retryable(urlErr.Err)
+	}
+	var ftpReply *textproto.Error
+	if errors.As(err, &ftpReply) {
+		return ftpReply.Code >= 400 && ftpReply.Code < 500
+	}
+	var netErr net.Error
+	var tmp temporaryError
+	return errors.As(err, &netErr) || errors.As(err, &tmp) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// withRetry runs fn, and again up to -retries times while it fails with a
+// retryable error. The delay starts at -retry-backoff and doubles on every
+// attempt, varied by up to ±-retry-jitter of itself.
+func withRetry(ctx context.Context, cfg *Config, what string, fn func() error) error {
+	delay := cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > cfg.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		wait := delay
+		if cfg.RetryJitter > 0 {
+			wait += time.Duration((rand.Float64()*2 - 1) * cfg.RetryJitter * float64(delay))
+		}
+		logWarn(fmt.Sprintf("%s failed: %v, retrying in %s (%d of %d)", what, err, wait.Round(time.Millisecond), attempt, cfg.Retries))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
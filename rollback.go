@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// runRollback restores the most recent -backups backup and reloads the
+// firewall. The backup is removed once restored, so running it again goes
+// back one more update.
+func runRollback(args []string) error {
+	var list bool
+	cfg, _, err := loadConfig("rollback", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindFlags(fs)
+		fs.BoolVar(&list, "list", false, "list the backups instead of restoring one")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	dirs, err := listBackups(cfg)
+	if err != nil {
+		return err
+	}
+	if list {
+		return printBackups(dirs)
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no backup in %s, enable them with -backups", cfg.backupPath())
+	}
+
+	unlock, err := acquireLock(cfg.lockPath(), cfg.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir := dirs[len(dirs)-1]
+	m, err := loadBackup(dir)
+	if err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("Restoring the backup of %s taken at %s", backupLabel(m), m.Created.Local().Format("2006-01-02 15:04:05")))
+	if err := restoreBackup(dir, m); err != nil {
+		return err
+	}
+
+	if cfg.NoReload {
+		logInfo("Skipping the reload (-no-reload).")
+	} else if cfg.ReloadCmd == "" && os.Getuid() != 0 {
+		logWarn("Not running as root, skipping the reload (set -reload-cmd, e.g. \"sudo nft -f /etc/nftables.conf\")")
+	} else if names := reloadNames(cfg); len(names) > 0 {
+		logInfo("Reloading (" + strings.Join(names, ", ") + ")...")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := reloadAll(ctx, cfg, nil); err != nil {
+			return err
+		}
+	}
+	logInfo("Rolled back. The next update installs the latest release again.")
+	return nil
+}
+
+// restoreBackup installs the files of the backup m in dir back where they
+// came from and removes the backup.
+func restoreBackup(dir string, m *backupManifest) error {
+	for _, f := range m.Files {
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+			return err
+		}
+		if err := installFile(filepath.Join(dir, f.Name), f.Path); err != nil {
+			return err
+		}
+		logInfo("- " + f.Path)
+	}
+	return os.RemoveAll(dir)
+}
+
+// restoreLatestBackup restores the most recent backup, the one taken before
+// this update replaced the files.
+func restoreLatestBackup(cfg *Config) error {
+	dirs, err := listBackups(cfg)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no backup in %s", cfg.backupPath())
+	}
+	dir := dirs[len(dirs)-1]
+	m, err := loadBackup(dir)
+	if err != nil {
+		return err
+	}
+	logInfo("Restoring the backup of " + backupLabel(m) + " from " + dir)
+	return restoreBackup(dir, m)
+}
+
+// loadBackup reads the manifest of the backup in dir.
+func loadBackup(dir string) (*backupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m backupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	for _, f := range m.Files {
+		if f.Path == "" || !filepath.IsLocal(f.Name) || filepath.Base(f.Name) != f.Name {
+			return nil, fmt.Errorf("%s: invalid entry %q", dir, f.Name)
+		}
+	}
+	return &m, nil
+}
+
+// backupLabel names the release a backup holds.
+func backupLabel(m *backupManifest) string {
+	if m.Tag == "" {
+		return "the MMDB"
+	}
+	return "release " + m.Tag
+}
+
+// printBackups lists the backups in dirs, the one rollback restores last.
+func printBackups(dirs []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tTAG\tFILES\tDIRECTORY")
+	for _, dir := range dirs {
+		m, err := loadBackup(dir)
+		if err != nil {
+			return err
+		}
+		tag := m.Tag
+		if tag == "" {
+			tag = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", m.Created.Local().Format("2006-01-02 15:04:05"), tag, len(m.Files), dir)
+	}
+	return w.Flush()
+}
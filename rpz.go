@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// rpzTTL is the TTL of the generated response policy zone records.
+const rpzTTL = 300
+
+// rpzZone returns the zone name for country: -rpz-zone, or <cc>.geo.rpz.
+func (c *Config) rpzZone(country string) string {
+	if c.RPZZone != "" {
+		return strings.TrimSuffix(c.RPZZone, ".")
+	}
+	return strings.ToLower(country) + ".geo.rpz"
+}
+
+// writeRPZ writes a BIND response policy zone with an rpz-ip trigger for
+// every prefix of sets, answering NXDOMAIN for names resolving into them. The
+// SOA serial is derived from the records, so the zone only changes, and BIND
+// only reloads it, when they do.
+func writeRPZ(cfg *Config, path string, sets []setSpec) error {
+	zone := cfg.rpzZone(sets[0].Country)
+	ns := strings.TrimSuffix(cfg.RPZNameserver, ".") + "."
+	var records strings.Builder
+	fmt.Fprintf(&records, "@ IN NS %s\n", ns)
+	for _, s := range sets {
+		prefixes, err := elementPrefixes(s)
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			fmt.Fprintf(&records, "%s.rpz-ip CNAME .\n", rpzOwner(p))
+		}
+	}
+	sum := sha256.Sum256([]byte(zone + "\n" + records.String()))
+	serial := binary.BigEndian.Uint32(sum[:4])
+
+	return writeLines(path, func(w *bufio.Writer) error {
+		fmt.Fprintf(w, "$ORIGIN %s.\n$TTL %d\n", zone, rpzTTL)
+		fmt.Fprintf(w, "@ IN SOA %s hostmaster.%s. %d 3600 600 86400 %d\n", ns, zone, serial, rpzTTL)
+		_, err := w.WriteString(records.String())
+		return err
+	})
+}
+
+// rpzOwner encodes p the way BIND expects rpz-ip triggers: the prefix length
+// followed by the address labels in reverse order, 1.0.1.0/24 becoming
+// 24.0.1.0.1. IPv6 uses the 16-bit groups in hex with the longest run of
+// zero groups replaced by "zz", 2001:250::/31 becoming 31.zz.250.2001.
+func rpzOwner(p netip.Prefix) string {
+	p = p.Masked()
+	addr := p.Addr().Unmap()
+	bits := p.Bits()
+	if addr.Is4() && p.Addr().Is4In6() {
+		bits -= 96
+	}
+
+	var labels []string
+	if addr.Is4() {
+		for _, b := range addr.As4() {
+			labels = append(labels, strconv.Itoa(int(b)))
+		}
+	} else {
+		b := addr.As16()
+		groups := make([]int, 8)
+		for i := range groups {
+			groups[i] = int(b[2*i])<<8 | int(b[2*i+1])
+		}
+		start, length := longestZeroRun(groups)
+		for i := 0; i < len(groups); i++ {
+			if length > 1 && i == start {
+				labels = append(labels, "zz")
+				i += length - 1
+				continue
+			}
+			labels = append(labels, strconv.FormatInt(int64(groups[i]), 16))
+		}
+	}
+
+	out := []string{strconv.Itoa(bits)}
+	for i := len(labels) - 1; i >= 0; i-- {
+		out = append(out, labels[i])
+	}
+	return strings.Join(out, ".")
+}
+
+// longestZeroRun returns the start and length of the first longest run of
+// zero groups.
+func longestZeroRun(groups []int) (start, length int) {
+	for i := 0; i < len(groups); {
+		if groups[i] != 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(groups) && groups[j] == 0 {
+			j++
+		}
+		if j-i > length {
+			start, length = i, j-i
+		}
+		i = j
+	}
+	return start, length
+}
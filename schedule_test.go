@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    schedule
+		wantErr bool
+	}{
+		{in: "6h", want: intervalSchedule(6 * time.Hour)},
+		{in: "@every 30m", want: intervalSchedule(30 * time.Minute)},
+		{in: "30s", wantErr: true},
+		{in: "@daily", want: cronSchedule{minute: 1, hour: 1, dom: bits(1, 31), month: bits(1, 12), dow: bits(0, 7), domAny: true, dowAny: true}},
+		{in: "0 4 * * 1-5", want: cronSchedule{minute: 1, hour: 1 << 4, dom: bits(1, 31), month: bits(1, 12), dow: bits(1, 5), domAny: true}},
+		{in: "0 0 * * 7", want: cronSchedule{minute: 1, hour: 1, dom: bits(1, 31), month: bits(1, 12), dow: 1<<0 | 1<<7, domAny: true}},
+		{in: "* * * *", wantErr: true},
+		{in: "0 0 * * * *", wantErr: true},
+		{in: "60 * * * *", wantErr: true},
+		{in: "0 24 * * *", wantErr: true},
+		{in: "0 0 0 * *", wantErr: true},
+		{in: "0 0 * 13 *", wantErr: true},
+		{in: "0 0 * * 8", wantErr: true},
+		{in: "5-1 * * * *", wantErr: true},
+		{in: "*/0 * * * *", wantErr: true},
+		{in: "a * * * *", wantErr: true},
+		{in: "1-x * * * *", wantErr: true},
+		{in: "@weekday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSchedule(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSchedule(%q) = %+v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSchedule(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSchedule(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max int
+		want     uint64
+	}{
+		{"*", 0, 59, bits(0, 59)},
+		{"5", 0, 59, 1 << 5},
+		{"1,3,5", 0, 59, 1<<1 | 1<<3 | 1<<5},
+		{"10-12", 0, 59, bits(10, 12)},
+		{"*/15", 0, 59, 1<<0 | 1<<15 | 1<<30 | 1<<45},
+		{"1-5/2", 0, 23, 1<<1 | 1<<3 | 1<<5},
+		{"10/20", 0, 59, 1<<10 | 1<<30 | 1<<50},
+		{"*/5", 1, 12, 1<<1 | 1<<6 | 1<<11},
+		{"0-2,22-23", 0, 23, bits(0, 2) | bits(22, 23)},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.in, tt.min, tt.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr, from, want string
+	}{
+		// Strictly after: a matching minute moves on to the next match.
+		{"0 4 * * *", "2026-10-14 04:00", "2026-10-15 04:00"},
+		{"*/15 * * * *", "2026-10-14 10:07", "2026-10-14 10:15"},
+		{"0 4 * * *", "2026-12-31 05:00", "2027-01-01 04:00"},
+		{"30 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:30"},
+		{"0 0 31 * *", "2026-04-01 00:00", "2026-05-31 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"@yearly", "2026-10-14 00:00", "2027-01-01 00:00"},
+		// 2026-10-14 is a Wednesday; 7 is Sunday like 0.
+		{"0 12 * * 7", "2026-10-14 00:00", "2026-10-18 12:00"},
+		{"0 0 * * 1", "2026-10-12 01:00", "2026-10-19 00:00"},
+		// A restricted day of month and day of week match when either does.
+		{"0 0 13 * 1", "2026-10-12 01:00", "2026-10-13 00:00"},
+		{"0 0 13 * 1", "2026-10-14 00:00", "2026-10-19 00:00"},
+		// With a "*" day of month only the day of week counts.
+		{"0 0 */1 * 1", "2026-10-12 01:00", "2026-10-19 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.expr, err)
+		}
+		if got := s.next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+
+	s, err := parseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.next(at("2026-10-14 00:00")); !got.IsZero() {
+		t.Errorf("February 31 matched %s", got)
+	}
+}
+
+// bits returns the bit set of lo to hi.
+func bits(lo, hi int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v++ {
+		set |= 1 << v
+	}
+	return set
+}
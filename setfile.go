@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// setSpec describes one generated nftables set.
+type setSpec struct {
+	Name string
+	// Country is the ISO code of the set, or the not_<codes> group of an
+	// -invert set.
+	Country  string
+	Family   string // "ipv4" or "ipv6"
+	AddrType string
+	// TypeDecl replaces the type declaration of the set file when set,
+	// e.g. "typeof ip daddr".
+	TypeDecl string
+	Path     string
+	Elements []string
+}
+
+// countrySets are the set_overrides of one country.
+type countrySets struct {
+	IPv4 setOverride `yaml:"ipv4"`
+	IPv6 setOverride `yaml:"ipv6"`
+}
+
+// setOverride replaces the derived name, file and type declaration of a set;
+// empty fields keep the default.
+type setOverride struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+	Type string `yaml:"type"`
+}
+
+func (c countrySets) forFamily(family string) setOverride {
+	if family == "ipv6" {
+		return c.IPv6
+	}
+	return c.IPv4
+}
+
+// generatedSets lists the sets produced by an update run, an IPv4 and an
+// IPv6 set for every -country and -subdivision, or only those of -ipv4-only
+// or -ipv6-only. With -invert there is a single group, see invertedGroup.
+func generatedSets(cfg *Config) []setSpec {
+	groups := cfg.groups()
+	if cfg.Invert {
+		groups = []string{invertedGroup(cfg)}
+	}
+	var sets []setSpec
+	for _, group := range groups {
+		for _, family := range cfg.families() {
+			sets = append(sets, countrySet(cfg, group, family))
+		}
+	}
+	return sets
+}
+
+// setDirs returns the directories of the set files, which set_overrides may
+// place outside out_dir.
+func setDirs(sets []setSpec) []string {
+	var dirs []string
+	for _, s := range sets {
+		if dir := filepath.Dir(s.Path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// families returns the address families sets are generated for.
+func (c *Config) families() []string {
+	switch {
+	case c.IPv4Only:
+		return []string{"ipv4"}
+	case c.IPv6Only:
+		return []string{"ipv6"}
+	}
+	return []string{"ipv4", "ipv6"}
+}
+
+// subdivisionGroup is the set group of subdivision sub of country cc, e.g.
+// CN_GD for Guangdong, giving the sets cn_gd4 and cn_gd6.
+func subdivisionGroup(cc, sub string) string {
+	return cc + "_" + sub
+}
+
+// invertedGroup names the -invert sets after the excluded countries, e.g.
+// not_cn_ru for -country CN,RU, giving the sets not_cn_ru4 and not_cn_ru6.
+func invertedGroup(cfg *Config) string {
+	return "not_" + strings.ToLower(strings.Join(cfg.countries(), "_"))
+}
+
+// countrySet describes the set of one country and family, named after the
+// lower-case country code and the IP version, e.g. cn4, and written to
+// out_dir, unless set_overrides says otherwise. Groups ending in a digit,
+// the AS numbers, get an underscore before the version: as4134_4.
+func countrySet(cfg *Config, country, family string) setSpec {
+	o := cfg.SetOverrides[country].forFamily(family)
+	sep := ""
+	if last := country[len(country)-1]; last >= '0' && last <= '9' {
+		sep = "_"
+	}
+	name := cmp.Or(o.Name, strings.ToLower(country)+sep+family[len(family)-1:])
+	return setSpec{
+		Name:     name,
+		Country:  country,
+		Family:   family,
+		AddrType: family + "_addr",
+		TypeDecl: o.Type,
+		Path:     cmp.Or(o.Path, filepath.Join(cfg.OutDir, name+".nft")),
+	}
+}
+
+// outputFiles lists every file an update run with cfg writes.
+func outputFiles(cfg *Config) []string {
+	var files []string
+	for _, s := range generatedSets(cfg) {
+		for _, name := range cfg.backends() {
+			// Combined backends share one file between sets.
+			if path := backendPath(cfg, name, s); path != "" && !slices.Contains(files, path) {
+				files = append(files, path)
+				if name == "pf" && cfg.PFSnippet {
+					files = append(files, pfSnippetPath(path))
+				}
+				if name == "sing-box" && cfg.SingboxSRS {
+					files = append(files, srsPath(path))
+				}
+				if name == "fw4" && fw4RulePath(cfg, s) != "" {
+					files = append(files, fw4RulePath(cfg, s))
+				}
+			}
+		}
+		if cfg.hasBackend("nft") && cfg.hasOutputFormat("binary") {
+			files = append(files, binaryPath(s.Path))
+		}
+	}
+	if cfg.CountryMetadataFile != "" {
+		files = append(files, cfg.CountryMetadataFile)
+	}
+	return files
+}
+
+// reloadedFiles lists the outputFiles a reload reads, which leaves out the
+// country metadata.
+func reloadedFiles(cfg *Config) []string {
+	return slices.DeleteFunc(outputFiles(cfg), func(path string) bool {
+		return path == cfg.CountryMetadataFile
+	})
+}
+
+// fileHashes returns the SHA256 of every file in paths that can be read.
+func fileHashes(paths []string) map[string][sha256.Size]byte {
+	hashes := map[string][sha256.Size]byte{}
+	for _, path := range paths {
+		if sum, err := fileSHA256(path); err == nil {
+			hashes[path] = sum
+		}
+	}
+	return hashes
+}
+
+// missingOutput returns the first of the installed MMDB and the outputFiles
+// that doesn't exist, or "" when all of them do.
+func missingOutput(cfg *Config) string {
+	for _, path := range append([]string{cfg.MMDBPath}, outputFiles(cfg)...) {
+		if _, err := os.Stat(path); err != nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func familyLabel(family string) string {
+	if family == "ipv6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// writeSet writes the output files of one set: the nftables file, produced
+// by the post-processor when one is configured, and the binary copy if
+// requested.
+func writeSet(cfg *Config, s setSpec, tag string) error {
+	if cfg.PostProcessor != "" {
+		data, err := runPostProcessor(cfg.PostProcessor, s, tag)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.Path, data); err != nil {
+			return err
+		}
+	} else {
+		style, err := cfg.nftStyle()
+		if err != nil {
+			return err
+		}
+		items, style := style.forElements(s.Elements)
+		if _, err := writeSetFile(context.Background(), s.Path, s, sendAll(items), style); err != nil {
+			return err
+		}
+	}
+
+	if cfg.hasOutputFormat("binary") {
+		return writeBinarySet(binaryPath(s.Path), s.AddrType, s.Elements)
+	}
+	return nil
+}
+
+// runPostProcessor feeds the plain prefix list of s to command, one prefix
+// per line, and returns what it prints. A non-zero exit aborts the write.
+func runPostProcessor(command string, s setSpec, tag string) ([]byte, error) {
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"MMDB_COUNTRY="+s.Country,
+		"MMDB_ADDR_FAMILY="+s.Family,
+		"MMDB_TAG="+tag,
+		"MMDB_COUNT="+strconv.Itoa(len(s.Elements)),
+	)
+	cmd.Stdin = strings.NewReader(strings.Join(s.Elements, "\n") + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("post-processor for %s failed: %v: %s", s.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// writeSetFile writes the elements received on items as the nftables set s
+// and returns how many there were. The header is written before the first
+// element arrives, so a producer can stream elements while it finds them.
+// The file replaces path once items is closed, unless ctx is done by then.
+func writeSetFile(ctx context.Context, path string, s setSpec, items <-chan string, style nftStyle) (int, error) {
+	af, err := createAtomic(path)
+	if err != nil {
+		// Drain items so the producer doesn't block forever.
+		for range items {
+		}
+		return 0, err
+	}
+	f := bufio.NewWriter(af)
+
+	in1 := strings.Repeat(" ", style.Indent)
+	in2 := in1 + in1
+
+	if style.UsageComment {
+		fmt.Fprint(f, usageComment(path, s, style))
+	}
+
+	fmt.Fprintf(f, "set %s {\n", s.Name)
+	fmt.Fprintf(f, "%s%s\n", in1, cmp.Or(s.TypeDecl, style.typeDecl(s.AddrType)))
+	if !style.HostOnly {
+		fmt.Fprintf(f, "%sflags interval\n", in1)
+	}
+	fmt.Fprintf(f, "%selements = {\n", in1)
+
+	// Hold back one element so the last one can be written without a
+	// trailing comma.
+	n := 0
+	var prev string
+	for item := range items {
+		if n > 0 {
+			fmt.Fprintf(f, "%s%s,\n", in2, prev)
+		}
+		prev = item
+		n++
+	}
+	if n > 0 {
+		sep := ","
+		if !style.TrailingComma {
+			sep = ""
+		}
+		fmt.Fprintf(f, "%s%s%s\n", in2, prev, sep)
+	}
+
+	fmt.Fprintf(f, "%s}\n}\n", in1)
+	err = f.Flush()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		af.abort()
+		return n, err
+	}
+	return n, af.commit()
+}
+
+// sendAll returns a channel that yields items and is then closed.
+func sendAll(items []string) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for _, item := range items {
+			ch <- item
+		}
+	}()
+	return ch
+}
+
+// forElements resolves HostOnly for a set holding items: it stays set only
+// when every item is a single address, and those are then returned without
+// prefix notation, which sets lacking the interval flag reject.
+func (s nftStyle) forElements(items []string) ([]string, nftStyle) {
+	if !s.HostOnly {
+		return items, s
+	}
+	hosts, ok := hostElements(items)
+	if !ok {
+		s.HostOnly = false
+		return items, s
+	}
+	return hosts, s
+}
+
+// hostElements returns items as bare addresses if every item is a /32 or
+// /128 prefix (or already a bare address).
+func hostElements(items []string) ([]string, bool) {
+	hosts := make([]string, len(items))
+	for i, item := range items {
+		p, err := parsePrefix(item)
+		if err != nil || !p.IsSingleIP() {
+			return nil, false
+		}
+		hosts[i] = p.Addr().String()
+	}
+	return hosts, true
+}
+
+// loadSet reads the elements of a generated set, from its binary copy when
+// binary is true.
+func loadSet(s setSpec, binary bool) ([]string, error) {
+	if binary {
+		return readBinarySet(binaryPath(s.Path))
+	}
+	return readSetFile(s.Path)
+}
+
+// readSetFile returns the elements of an nftables set file as written by
+// writeSetFile.
+func readSetFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop comments so they can't confuse the element scan.
+	var b strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	text := b.String()
+
+	i := strings.Index(text, "elements")
+	if i < 0 {
+		return nil, nil
+	}
+	open := strings.Index(text[i:], "{")
+	if open < 0 {
+		return nil, fmt.Errorf("%s: malformed elements block", path)
+	}
+	body := text[i+open+1:]
+	end := strings.Index(body, "}")
+	if end < 0 {
+		return nil, fmt.Errorf("%s: unterminated elements block", path)
+	}
+
+	var elems []string
+	for _, field := range strings.FieldsFunc(body[:end], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		elems = append(elems, field)
+	}
+	return elems, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// reloadModes are the values of -reload-mode: restart or reload the nftables
+// service, load only the generated sets with nft -f, or program them over
+// netlink.
+var reloadModes = []string{"restart", "reload", "sets", "netlink"}
+
+// setsScriptPath is the script -reload-mode sets loads. It lives next to the
+// state file rather than in out_dir, where an include of *.nft would pick it
+// up.
+func (c *Config) setsScriptPath() string {
+	return filepath.Join(filepath.Dir(c.StateFile), "reload-sets.nft")
+}
+
+// setsScriptBatch is how many elements one add element command of the
+// -reload-mode sets script, or one netlink message of -reload-mode netlink,
+// holds, which keeps each message small.
+const setsScriptBatch = 1000
+
+// writeSetsScript writes the script of -reload-mode sets: every generated set
+// is declared in -nft-table, in case the ruleset doesn't have it yet, flushed
+// and refilled with add element commands of setsScriptBatch elements from its
+// file. nft -f applies the whole script as a single transaction, so the
+// kernel swaps in the new elements at once and a set is never seen empty;
+// every other table, chain and set is left alone.
+func writeSetsScript(cfg *Config) error {
+	return writeLines(cfg.setsScriptPath(), func(w *bufio.Writer) error {
+		for _, s := range generatedSets(cfg) {
+			decl, err := setDeclaration(s.Path)
+			if err != nil {
+				return err
+			}
+			elems, err := readSetFile(s.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "table %s {\n%s}\n", cfg.NftTable, decl)
+			fmt.Fprintf(w, "flush set %s %s\n", cfg.NftTable, s.Name)
+			for batch := range slices.Chunk(elems, setsScriptBatch) {
+				fmt.Fprintf(w, "add element %s %s { %s }\n", cfg.NftTable, s.Name, strings.Join(batch, ", "))
+			}
+		}
+		return nil
+	})
+}
+
+// setDeclaration returns the set definition of the file at path without its
+// elements, which declares the set with the same type and flags.
+func setDeclaration(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "elements") {
+			b.WriteString("\t}\n")
+			return b.String(), nil
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			b.WriteString("\t" + sc.Text() + "\n")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s: no set definition found", path)
+}
@@ -0,0 +1,56 @@
+//go:build testing
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Failure injection for exercising the error paths, only compiled into
+// builds made with -tags testing.
+var (
+	simulateDownloadFailure int
+	simulateReloadFailure   bool
+	downloadAttempts        int
+)
+
+func bindSimulationFlags(fs *flag.FlagSet) {
+	fs.IntVar(&simulateDownloadFailure, "simulate-download-failure", simulateDownloadFailure, "testing build only: fail the Nth download attempt")
+	fs.BoolVar(&simulateReloadFailure, "simulate-reload-failure", simulateReloadFailure, "testing build only: make the reload command exit with status 1")
+}
+
+// simulationWarning describes the active failure injections, or returns "".
+func simulationWarning() string {
+	var active []string
+	if simulateDownloadFailure > 0 {
+		active = append(active, fmt.Sprintf("download attempt %d will fail", simulateDownloadFailure))
+	}
+	if simulateReloadFailure {
+		active = append(active, "the reload command will fail")
+	}
+	if len(active) == 0 {
+		return ""
+	}
+	return "FAILURE SIMULATION ACTIVE: " + strings.Join(active, ", ")
+}
+
+// simulatedDownloadError counts a download attempt and fails the one chosen
+// with -simulate-download-failure.
+func simulatedDownloadError() error {
+	downloadAttempts++
+	if downloadAttempts == simulateDownloadFailure {
+		return temporaryError{fmt.Errorf("simulated download failure (attempt %d)", downloadAttempts)}
+	}
+	return nil
+}
+
+// simulatedReloadCommand replaces args with a command exiting with status 1
+// when -simulate-reload-failure is set.
+func simulatedReloadCommand(args []string) []string {
+	if simulateReloadFailure {
+		return []string{"false"}
+	}
+	return args
+}
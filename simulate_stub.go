@@ -0,0 +1,13 @@
+//go:build !testing
+
+package main
+
+import "flag"
+
+func bindSimulationFlags(*flag.FlagSet) {}
+
+func simulationWarning() string { return "" }
+
+func simulatedDownloadError() error { return nil }
+
+func simulatedReloadCommand(args []string) []string { return args }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"net/netip"
+	"strings"
+)
+
+// singboxRuleSetVersion is the sing-box rule-set version written to the JSON
+// source and the .srs header. Version 1 is understood by every release that
+// supports rule-sets and is enough for ip_cidr rules.
+const singboxRuleSetVersion = 1
+
+// Item types of the sing-box binary rule-set format (common/srs in
+// sing-box).
+const (
+	srsRuleTypeDefault = 0
+	srsItemIPCIDR      = 6
+	srsItemFinal       = 0xff
+)
+
+// srsPath returns the compiled rule-set written next to the JSON source
+// path with -singbox-srs.
+func srsPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".srs"
+}
+
+// writeSingbox writes a sing-box source rule-set with a single ip_cidr rule
+// holding the prefixes of sets, and with -singbox-srs the same rule-set in
+// the binary .srs format that `sing-box rule-set compile` produces.
+func writeSingbox(cfg *Config, path string, sets []setSpec) error {
+	cidrs := []string{}
+	for _, s := range sets {
+		cidrs = append(cidrs, s.Elements...)
+	}
+	source := struct {
+		Version int                   `json:"version"`
+		Rules   []map[string][]string `json:"rules"`
+	}{singboxRuleSetVersion, []map[string][]string{{"ip_cidr": cidrs}}}
+	data, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return err
+	}
+	if !cfg.SingboxSRS {
+		return nil
+	}
+	return writeSRS(srsPath(path), sets)
+}
+
+// writeSRS writes the binary rule-set: the magic "SRS", the version byte and
+// a zlib stream with the rule count, then for the one default rule its
+// ip_cidr item, the final marker and the invert flag. The item holds the
+// merged address ranges as a format version byte, a big-endian uint64 count
+// and uvarint-length-prefixed from/to addresses.
+func writeSRS(path string, sets []setSpec) error {
+	var prefixes []netip.Prefix
+	for _, s := range sets {
+		ps, err := elementPrefixes(s)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, ps...)
+	}
+	ranges := mergeRanges(prefixes)
+
+	return writeLines(path, func(w *bufio.Writer) error {
+		w.WriteString("SRS")
+		w.WriteByte(singboxRuleSetVersion)
+		zw, err := zlib.NewWriterLevel(w, zlib.BestCompression)
+		if err != nil {
+			return err
+		}
+		buf := binary.AppendUvarint(nil, 1) // rule count
+		buf = append(buf, srsRuleTypeDefault, srsItemIPCIDR)
+		buf = append(buf, 1) // IP set format version
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(ranges)))
+		for _, r := range ranges {
+			for _, addr := range []netip.Addr{r.From, r.To} {
+				b := addr.AsSlice()
+				buf = binary.AppendUvarint(buf, uint64(len(b)))
+				buf = append(buf, b...)
+			}
+		}
+		buf = append(buf, srsItemFinal, 0) // not inverted
+		if _, err := zw.Write(buf); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
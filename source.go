@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// geoSource is a provider of country MMDBs. They hold the same data in
+// different record layouts, which decode turns into a CountryRecord.
+type geoSource interface {
+	// downloadURL returns where the database is downloaded from, or "" for
+	// the GitHub release.
+	downloadURL(cfg *Config) string
+	// decode reads the record with read, which decodes into the value it is
+	// given, and returns it as a CountryRecord.
+	decode(read func(v any) error) (CountryRecord, error)
+}
+
+// sources are the accepted -source values.
+var sources = map[string]geoSource{
+	"maxmind": maxmindSource{},
+	"dbip":    dbipSource{},
+	"ipinfo":  ipinfoSource{},
+}
+
+func sourceNames() string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// source returns the -source provider.
+func (c *Config) source() geoSource {
+	return sources[c.Source]
+}
+
+// nextRecord decodes the current network of networks with src.
+func nextRecord(src geoSource, networks *maxminddb.Networks) (*net.IPNet, CountryRecord, error) {
+	var network *net.IPNet
+	rec, err := src.decode(func(v any) (err error) {
+		network, err = networks.Network(v)
+		return err
+	})
+	return network, rec, err
+}
+
+// lookupRecord looks ip up in db with src.
+func lookupRecord(src geoSource, db *maxminddb.Reader, ip net.IP) (CountryRecord, error) {
+	return src.decode(func(v any) error {
+		return db.Lookup(ip, v)
+	})
+}
+
+// maxmindSource reads GeoLite2 and GeoIP2 databases, from the GitHub release
+// or with -maxmind-license-key from MaxMind.
+type maxmindSource struct{}
+
+func (maxmindSource) downloadURL(*Config) string { return "" }
+
+func (maxmindSource) decode(read func(v any) error) (CountryRecord, error) {
+	var rec CountryRecord
+	err := read(&rec)
+	return rec, err
+}
+
+// dbipSource reads the DB-IP Lite country database, published monthly in the
+// GeoIP2 layout.
+type dbipSource struct{}
+
+func (dbipSource) downloadURL(*Config) string {
+	return "https://download.db-ip.com/free/dbip-country-lite-" + time.Now().UTC().Format("2006-01") + ".mmdb.gz"
+}
+
+func (dbipSource) decode(read func(v any) error) (CountryRecord, error) {
+	return maxmindSource{}.decode(read)
+}
+
+// ipinfoSource reads the ipinfo free country database, whose records are
+// flat: the country and continent are codes next to their English names.
+type ipinfoSource struct{}
+
+type ipinfoRecord struct {
+	Country       string `maxminddb:"country"`
+	CountryName   string `maxminddb:"country_name"`
+	Continent     string `maxminddb:"continent"`
+	ContinentName string `maxminddb:"continent_name"`
+}
+
+func (ipinfoSource) downloadURL(cfg *Config) string {
+	return "https://ipinfo.io/data/free/country.mmdb?token=" + url.QueryEscape(cfg.IPInfoToken)
+}
+
+func (ipinfoSource) decode(read func(v any) error) (CountryRecord, error) {
+	var r ipinfoRecord
+	var rec CountryRecord
+	if err := read(&r); err != nil {
+		return rec, err
+	}
+	rec.Country.ISOCode = r.Country
+	rec.Continent.Code = r.Continent
+	if r.CountryName != "" {
+		rec.Country.Names = map[string]string{"en": r.CountryName}
+	}
+	if r.ContinentName != "" {
+		rec.Continent.Names = map[string]string{"en": r.ContinentName}
+	}
+	return rec, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const systemStateFile = "/var/lib/auto-update-mmdb/state.json"
+
+// runState is persisted between runs.
+type runState struct {
+	Tag       string    `json:"tag,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Counts holds the number of elements last written to each set.
+	Counts map[string]int `json:"counts,omitempty"`
+	// Release caches the latest release API response Tag came from.
+	Release *apiCache `json:"release,omitempty"`
+	// MMDBURL caches the validators of the last -mmdb-url download.
+	MMDBURL *apiCache `json:"mmdb_url,omitempty"`
+}
+
+// loadState reads the state file; a missing file yields an empty state.
+func loadState(path string) (*runState, error) {
+	st := &runState{Counts: map[string]int{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	if st.Counts == nil {
+		st.Counts = map[string]int{}
+	}
+	return st, nil
+}
+
+func saveState(path string, st *runState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	st.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateData is what a -template is rendered with, once per set.
+type templateData struct {
+	SetName  string
+	Country  string
+	Family   string // "ipv4" or "ipv6"
+	AddrType string // "ipv4_addr" or "ipv6_addr"
+	Elements []string
+}
+
+// loadTemplate parses the -template file.
+func (c *Config) loadTemplate() (*template.Template, error) {
+	return template.New(filepath.Base(c.Template)).Option("missingkey=error").ParseFiles(c.Template)
+}
+
+// templateExt returns the extension of the files rendered from -template:
+// the one left after dropping .tmpl, so cn4.conf is written for
+// sets.conf.tmpl.
+func (c *Config) templateExt() string {
+	return filepath.Ext(strings.TrimSuffix(filepath.Base(c.Template), ".tmpl"))
+}
+
+// writeTemplate renders -template for s. Nothing is written when the
+// template fails.
+func writeTemplate(cfg *Config, path string, sets []setSpec) error {
+	tmpl, err := cfg.loadTemplate()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, s := range sets {
+		data := templateData{
+			SetName:  s.Name,
+			Country:  s.Country,
+			Family:   s.Family,
+			AddrType: s.AddrType,
+			Elements: s.Elements,
+		}
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader reads from r at no more than rate bytes per second on
+// average, sleeping as long as the transfer is ahead of it.
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	done  int64
+}
+
+func newThrottledReader(r io.Reader, rate int64) *throttledReader {
+	return &throttledReader{r: r, rate: rate, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Small reads keep the rate even rather than bursting a large buffer
+	// and sleeping for seconds afterwards.
+	if chunk := max(t.rate/10, 1); int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.r.Read(p)
+	t.done += int64(n)
+	due := t.start.Add(time.Duration(float64(t.done) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// maxExpandedHosts caps -expand-to-hosts, beyond which unbound would need
+// many gigabytes for its local data.
+const maxExpandedHosts = 1 << maxExpandedBits
+
+// maxExpandedBits is the number of host bits of maxExpandedHosts.
+const maxExpandedBits = 24
+
+// writeUnbound writes an unbound.conf snippet with a local-data-ptr entry for
+// the network address of every prefix, or for every address they contain
+// with -expand-to-hosts.
+func writeUnbound(cfg *Config, path string, sets []setSpec) error {
+	var all []netip.Prefix
+	for _, s := range sets {
+		prefixes, err := elementPrefixes(s)
+		if err != nil {
+			return err
+		}
+		all = append(all, prefixes...)
+	}
+
+	if cfg.ExpandToHosts {
+		var hosts uint64
+		for _, p := range all {
+			// Checked per prefix first, so the sum below can't overflow.
+			bits := p.Addr().BitLen() - p.Bits()
+			if bits > maxExpandedBits {
+				return fmt.Errorf("-expand-to-hosts: %s alone holds 2^%d addresses, more than the limit of %d", p, bits, maxExpandedHosts)
+			}
+			hosts += 1 << bits
+		}
+		if hosts > maxExpandedHosts {
+			return fmt.Errorf("-expand-to-hosts: %s sets hold %d addresses, more than the limit of %d", sets[0].Country, hosts, maxExpandedHosts)
+		}
+		logWarn(fmt.Sprintf("-expand-to-hosts writes %d local-data-ptr entries to %s, expect a large file and a slow unbound reload", hosts, path))
+	}
+
+	target := strings.ToLower(sets[0].Country) + ".geoip.invalid."
+	return writeLines(path, func(w *bufio.Writer) error {
+		fmt.Fprintln(w, "server:")
+		for _, p := range all {
+			if !cfg.ExpandToHosts {
+				fmt.Fprintf(w, "    local-data-ptr: \"%s %s\"\n", p.Addr(), target)
+				continue
+			}
+			last := prefixLast(p)
+			for addr := p.Addr(); ; addr = addr.Next() {
+				fmt.Fprintf(w, "    local-data-ptr: \"%s %s\"\n", addr, target)
+				if addr == last {
+					break
+				}
+			}
+		}
+		return nil
+	})
+}
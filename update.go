@@ -0,0 +1,708 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+// runUpdate performs one full update: download the MMDB, regenerate the set
+// files and reload nftables. The outcome is recorded in stats and reported
+// to -ping-url and the notification channels. The update is abandoned when
+// ctx is done. A -dry-run goes to runDryRun and is not reported.
+func runUpdate(ctx context.Context, cfg *Config) error {
+	if cfg.DryRun {
+		return runDryRun(ctx, cfg)
+	}
+	sum := newRunSummary()
+	err := update(ctx, cfg, sum)
+	sum.Err, sum.Duration = err, time.Since(sum.Start)
+	stats.recordUpdate(sum.Start, err)
+	flushMetrics()
+	pingURL(cfg, err)
+	notify(cfg, sum)
+	return err
+}
+
+// update runs the update steps, filling in sum as it goes.
+func update(ctx context.Context, cfg *Config, sum *runSummary) error {
+	unlock, err := acquireLock(cfg.lockPath(), cfg.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cfg.Netns != "" {
+		if err := checkNetns(cfg); err != nil {
+			return err
+		}
+	}
+
+	st, err := loadState(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	maps.Copy(sum.Previous, st.Counts)
+	excl, err := cfg.exclusions()
+	if err != nil {
+		return err
+	}
+	extras, err := loadExtraCIDRs(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Skipping the run needs the installed files, so only make the requests
+	// conditional when they all exist.
+	missing := missingOutput(cfg)
+	upToDate := func() {
+		for _, set := range generatedSets(cfg) {
+			sum.Counts[set.Name] = st.Counts[set.Name]
+		}
+		sum.Reload = "skipped: already up to date"
+		if cfg.ReportUnchanged {
+			printReport(buildReport(cfg, st.Tag), cfg.hasOutputFormat("json"))
+		}
+	}
+
+	// 1. Resolve the MMDB download URL
+	var downloadURL, tag, checksumURL, signatureURL, asnURL string
+	var assetSize int64
+	if cfg.OfflineMMDB != "" {
+		// Nothing is looked up or downloaded, the MMDB is at hand.
+		logInfo("Offline mode, using the MMDB at " + cfg.OfflineMMDB)
+	} else if cfg.MMDBURL != "" {
+		// Download straight from the configured URL, bypassing GitHub.
+		downloadURL = cfg.MMDBURL
+		logInfo("Using MMDB URL: " + redactURL(downloadURL))
+		if st.MMDBURL == nil || cfg.Force || missing != "" {
+			st.MMDBURL = &apiCache{}
+		}
+	} else if cfg.FTPURL != "" {
+		downloadURL = cfg.FTPURL
+		logInfo("Using MMDB FTP URL: " + redactURL(downloadURL))
+	} else if u := cfg.source().downloadURL(cfg); u != "" {
+		downloadURL = u
+		logInfo("Using the " + cfg.Source + " download: " + redactURL(downloadURL))
+	} else if cfg.MaxMindLicenseKey != "" {
+		// MaxMind serves a tar.gz of the edition, always checksummed.
+		downloadURL = maxmindURL(cfg.MaxMindEdition, "tar.gz")
+		logInfo("Using the MaxMind download of " + cfg.MaxMindEdition)
+	} else {
+		if st.Release == nil || st.Tag == "" || cfg.Force || missing != "" {
+			st.Release = &apiCache{}
+		}
+		var release *GitHubRelease
+		err := withRetry(ctx, cfg, "Fetching the latest release", func() (err error) {
+			release, err = fetchLatestRelease(ctx, cfg.GitHubRepo, st.Release)
+			return err
+		})
+		if errors.Is(err, errNotModified) {
+			logInfo("The latest release did not change since the last run (" + st.Tag + "), nothing to do.")
+			sum.Tag = st.Tag
+			upToDate()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tag = release.TagName
+		sum.Tag = tag
+		logInfo("Latest tag: " + tag)
+		if tag == st.Tag && !cfg.Force {
+			if missing == "" {
+				logInfo("Already up to date (" + tag + "), use -force to update anyway.")
+				upToDate()
+				// Keep the validators of this response for the next run.
+				return saveState(cfg.StateFile, st)
+			}
+			logInfo(missing + " is missing, updating although " + tag + " is installed.")
+		}
+
+		// 2. Find mmdb download URL
+		for _, a := range release.Assets {
+			if a.Name == cfg.MMDBAsset {
+				downloadURL, assetSize = a.BrowserDownloadURL, a.Size
+				break
+			}
+		}
+		if downloadURL == "" {
+			return fmt.Errorf("%s not found in release %s of %s", cfg.MMDBAsset, tag, cfg.GitHubRepo)
+		}
+		if len(cfg.asns()) > 0 && cfg.ASNMMDBURL == "" {
+			for _, a := range release.Assets {
+				if a.Name == defaultASNAsset {
+					asnURL = a.BrowserDownloadURL
+				}
+			}
+			if asnURL == "" {
+				return fmt.Errorf("%s not found in release %s of %s, set -asn-mmdb-url", defaultASNAsset, tag, cfg.GitHubRepo)
+			}
+		}
+		if cfg.VerifyChecksum && cfg.ChecksumURL == "" {
+			if checksumURL, err = checksumAsset(release, cfg.MMDBAsset); err != nil {
+				return err
+			}
+		}
+		if cfg.GPGKey != "" && cfg.SignatureURL == "" {
+			if signatureURL, err = signatureAsset(release, cfg.MMDBAsset); err != nil {
+				return err
+			}
+		}
+
+		logInfo("MMDB download URL: " + downloadURL)
+	}
+
+	// 3. Download mmdb
+	downloadStart := time.Now()
+	opts := downloadOptions{MaxSize: int64(cfg.MaxDownloadSize), Decompress: cfg.Decompress}
+	if cfg.MMDBURL != "" {
+		opts.Header = cfg.mmdbHeader()
+		opts.User = cfg.HTTPUser
+		opts.Password = cfg.HTTPPassword
+		opts.Cache = st.MMDBURL
+	}
+	if cfg.FTPURL != "" {
+		opts.User = cfg.FTPUser
+		opts.Password = cfg.FTPPassword
+	}
+	var wantSum string
+	if cfg.MaxMindLicenseKey != "" {
+		opts.User, opts.Password, opts.Decompress = cfg.MaxMindAccountID, cfg.MaxMindLicenseKey, true
+		err := withRetry(ctx, cfg, "Downloading the checksum", func() (err error) {
+			wantSum, err = fetchMaxMindChecksum(ctx, cfg, opts)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		checksumURL = maxmindURL(cfg.MaxMindEdition, "tar.gz.sha256")
+		logDebug("Expected SHA256: " + wantSum)
+	} else if cfg.VerifyChecksum {
+		if cfg.ChecksumURL != "" {
+			checksumURL = cfg.ChecksumURL
+		} else if checksumURL == "" {
+			checksumURL = downloadURL + ".sha256"
+		}
+		asset, _ := url.Parse(downloadURL)
+		err := withRetry(ctx, cfg, "Downloading the checksum", func() (err error) {
+			wantSum, err = fetchChecksum(ctx, cfg, checksumURL, path.Base(asset.Path), downloadURL, opts)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		logDebug("Expected SHA256: " + wantSum)
+	}
+	var sig *signature
+	if cfg.GPGKey != "" {
+		if cfg.SignatureURL != "" {
+			signatureURL = cfg.SignatureURL
+		} else if signatureURL == "" {
+			signatureURL = downloadURL + ".asc"
+		}
+		err := withRetry(ctx, cfg, "Downloading the signature", func() (err error) {
+			sig, err = fetchSignature(ctx, cfg, signatureURL, downloadURL, opts)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	sha := sha256.New()
+	if cfg.OfflineMMDB != "" {
+		err = installFile(cfg.OfflineMMDB, cfg.TmpPath)
+	} else {
+		logInfo("Downloading MMDB...")
+		opts.Size, opts.Progress, opts.RateLimit = assetSize, cfg.ProgressInterval, int64(cfg.DownloadLimit)
+		err = withRetry(ctx, cfg, "Download", func() error {
+			return downloadMirrored(ctx, cfg, downloadURL, opts, sha, sig)
+		})
+	}
+	if errors.Is(err, errNotModified) {
+		logInfo("The MMDB at " + redactURL(downloadURL) + " did not change since the last run, nothing to do.")
+		sum.Tag = st.Tag
+		upToDate()
+		return nil
+	}
+	if err != nil {
+		os.Remove(cfg.TmpPath)
+		var serr *signatureError
+		if errors.As(err, &serr) {
+			return fmt.Errorf("GPG signature check of %s against %s failed: %w; the installed MMDB was left untouched", redactURL(serr.url), redactURL(signatureURL), serr.err)
+		}
+		return err
+	}
+	if wantSum != "" {
+		if got := hex.EncodeToString(sha.Sum(nil)); got != wantSum {
+			os.Remove(cfg.TmpPath)
+			return fmt.Errorf("SHA256 mismatch for %s: got %s, want %s from %s; the installed MMDB was left untouched", redactURL(downloadURL), got, wantSum, redactURL(checksumURL))
+		}
+		logInfo("SHA256 checksum verified.")
+	}
+	if err := extractMMDB(cfg.TmpPath, cmp.Or(int64(cfg.MaxDownloadSize), defaultMaxDownloadSize)); err != nil {
+		os.Remove(cfg.TmpPath)
+		return fmt.Errorf("%w; the installed MMDB was left untouched", err)
+	}
+
+	if cfg.OfflineMMDB == "" {
+		stats.recordDownload(tag, time.Since(downloadStart))
+		logInfo("Download complete.")
+	}
+	unchanged := sameContent(cfg.TmpPath, cfg.MMDBPath)
+	sum.Changed = !unchanged
+	if unchanged {
+		logInfo("The downloaded MMDB is identical to the installed one.")
+	}
+
+	meta, err := validateMMDB(cfg.TmpPath, cfg.source())
+	if err != nil {
+		os.Remove(cfg.TmpPath)
+		return fmt.Errorf("the downloaded MMDB is invalid, keeping %s: %w", cfg.MMDBPath, err)
+	}
+	if err := checkDowngrade(cfg, meta, unchanged); err != nil {
+		os.Remove(cfg.TmpPath)
+		return err
+	}
+	// Without a URL the installed ASN database is used as it is.
+	asnURL = cmp.Or(cfg.ASNMMDBURL, asnURL)
+	asnPath, asnTmp := cfg.asnMMDBPath(), cfg.TmpPath+".asn"
+	if len(cfg.asns()) > 0 && asnURL != "" {
+		if err := fetchASNDatabase(ctx, cfg, asnTmp, asnURL); err != nil {
+			os.Remove(cfg.TmpPath)
+			return err
+		}
+		defer os.Remove(asnTmp)
+	}
+	prev := previousElements(cfg, generatedSets(cfg))
+	if cfg.DryRun {
+		// From here on everything is written to the staging directory.
+		cfg = cfg.staged()
+	}
+	installed := fileHashes(reloadedFiles(cfg))
+
+	// 4. Back up and replace system MMDB
+	if cfg.Backups > 0 {
+		if err := backupInstalled(cfg, st.Tag); err != nil {
+			return fmt.Errorf("backup failed, the installed MMDB was left untouched: %w", err)
+		}
+	}
+	logInfo("Replacing old MMDB...")
+	if err := os.MkdirAll(filepath.Dir(cfg.MMDBPath), 0755); err != nil {
+		return err
+	}
+	if err := installFile(cfg.TmpPath, cfg.MMDBPath); err != nil {
+		return err
+	}
+	os.Remove(cfg.TmpPath) // Clean up temp file
+	if len(cfg.asns()) > 0 && asnURL != "" {
+		if cfg.DryRun {
+			asnPath = asnTmp
+		} else if err := installFile(asnTmp, asnPath); err != nil {
+			return err
+		}
+	}
+
+	// 5. Parse MMDB and extract the networks of the country
+	logInfo("Parsing MMDB and generating nftables sets...")
+	sets := generatedSets(cfg)
+	for _, dir := range append([]string{cfg.OutDir}, setDirs(sets)...) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	var walk func(emit func(country, family, cidr string)) map[string]string
+	if cfg.SimulateCountry != "" {
+		logWarn(bold(fmt.Sprintf("SIMULATION MODE: treating every IP address as %s, the generated sets are NOT real GeoIP data", cfg.SimulateCountry)))
+		walk = func(emit func(country, family, cidr string)) map[string]string {
+			selected := slices.Contains(cfg.countries(), cfg.SimulateCountry)
+			group := cfg.SimulateCountry
+			if cfg.Invert {
+				group = invertedGroup(cfg)
+			}
+			if selected != cfg.Invert {
+				for _, family := range cfg.families() {
+					all := "0.0.0.0/0"
+					if family == "ipv6" {
+						all = "::/0"
+					}
+					emit(group, family, all)
+				}
+			}
+			return map[string]string{}
+		}
+	} else {
+		db, err := maxminddb.Open(cfg.MMDBPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if cfg.Subdivision != "" && !strings.Contains(db.Metadata.DatabaseType, "City") {
+			return fmt.Errorf("-subdivision needs a City database, %s is %s", cfg.MMDBPath, db.Metadata.DatabaseType)
+		}
+		walk = func(emit func(country, family, cidr string)) map[string]string {
+			return walkNetworks(ctx, db, cfg, emit)
+		}
+	}
+
+	// Add the networks of the -asn numbers.
+	if len(cfg.asns()) > 0 {
+		asnDB, err := maxminddb.Open(asnPath)
+		if err != nil {
+			return fmt.Errorf("-asn: %w; set -asn-mmdb-url to download it", err)
+		}
+		defer asnDB.Close()
+		var countryDB *maxminddb.Reader
+		if cfg.ASNCountry != "" {
+			if countryDB, err = maxminddb.Open(cfg.MMDBPath); err != nil {
+				return err
+			}
+			defer countryDB.Close()
+		}
+		inner := walk
+		walk = func(emit func(country, family, cidr string)) map[string]string {
+			names := inner(emit)
+			maps.Copy(names, walkASNs(ctx, asnDB, countryDB, cfg, emit))
+			return names
+		}
+	}
+
+	// Take the -exclude networks out of whatever the walk produces.
+	excluded := 0
+	if len(excl) > 0 {
+		inner := walk
+		walk = func(emit func(country, family, cidr string)) map[string]string {
+			return inner(func(country, family, cidr string) {
+				p, err := netip.ParsePrefix(cidr)
+				if err != nil {
+					emit(country, family, cidr)
+					return
+				}
+				rest := excludeRanges(p, excl)
+				if len(rest) != 1 || rest[0] != p {
+					excluded++
+				}
+				for _, r := range rest {
+					emit(country, family, r.String())
+				}
+			})
+		}
+	}
+
+	var written []setSpec
+	var generated []string // "path (details)" of every file written
+	var countryNames map[string]string
+	if cfg.Streaming {
+		// 6. Write nftables set files while the MMDB is still being read
+		counts, names, err := streamSets(ctx, cfg, sets, walk)
+		if err != nil {
+			return err
+		}
+		maps.Copy(st.Counts, counts)
+		countryNames, written = names, sets
+		for _, set := range sets {
+			generated = append(generated, fmt.Sprintf("%s (%d %s ranges)", set.Path, counts[set.Name], familyLabel(set.Family)))
+		}
+	} else {
+		elements := map[string][]string{}
+		countryNames = walk(func(country, family, cidr string) {
+			elements[country+family] = append(elements[country+family], cidr)
+		})
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i := range sets {
+			sets[i].Elements = elements[sets[i].Country+sets[i].Family]
+			// Extra networks may overlap the database, so sets receiving
+			// them are always aggregated, as are the -continent sets, whose
+			// countries often border each other in the address space.
+			extra, ok := extraElements(extras, sets[i], excl)
+			if ok {
+				logInfo(fmt.Sprintf("Set %s: adding %d extra networks", sets[i].Name, len(extra)))
+				sets[i].Elements = append(sets[i].Elements, extra...)
+			}
+			if !cfg.Aggregate && cfg.Continent == "" && !ok {
+				continue
+			}
+			merged, err := aggregateElements(sets[i].Elements)
+			if err != nil {
+				return fmt.Errorf("set %s: %w", sets[i].Name, err)
+			}
+			logInfo(fmt.Sprintf("Set %s: aggregated %d networks into %d", sets[i].Name, len(sets[i].Elements), len(merged)))
+			sets[i].Elements = merged
+		}
+
+		if canaries := cfg.canaries(); len(canaries) > 0 {
+			if err := checkCanaries(canaries, sets); err != nil {
+				if cfg.CanaryAbortOnMiss {
+					return err
+				}
+				logWarn(err.Error())
+			}
+		}
+
+		// 6. Write nftables set files
+		var combined []setSpec // the sets as installed, written or kept
+		for _, set := range sets {
+			if prev, ok := st.Counts[set.Name]; ok && cfg.MinChangeThreshold > 0 && absDiff(len(set.Elements), prev) <= cfg.MinChangeThreshold {
+				// The combined outputs keep the elements of the kept file. A
+				// set whose file can't be read is missing or was never
+				// written as nft, so it is written like a changed one.
+				elems, err := readSetFile(set.Path)
+				if err == nil {
+					logInfo(fmt.Sprintf("Set %s changed by %d elements (threshold %d), keeping %s", set.Name, absDiff(len(set.Elements), prev), cfg.MinChangeThreshold, set.Path))
+					kept := set
+					kept.Elements = elems
+					combined = append(combined, kept)
+					continue
+				}
+				logInfo(fmt.Sprintf("Set %s is within -min-change-threshold but %s can't be read, writing it: %v", set.Name, set.Path, err))
+			}
+			paths, err := writeOutputs(cfg, set, tag)
+			if err != nil {
+				return err
+			}
+			for _, path := range paths {
+				generated = append(generated, fmt.Sprintf("%s (%d %s ranges)", path, len(set.Elements), familyLabel(set.Family)))
+			}
+			st.Counts[set.Name] = len(set.Elements)
+			written = append(written, set)
+			combined = append(combined, set)
+		}
+		if len(written) > 0 {
+			lines, err := writeCombinedOutputs(cfg, combined)
+			if err != nil {
+				return err
+			}
+			generated = append(generated, lines...)
+		}
+	}
+	if excluded > 0 {
+		logInfo(fmt.Sprintf("%d networks overlapped the excluded ranges and were removed or split", excluded))
+	}
+	diffs := diffSets(prev, written)
+	logDiffs(diffs)
+	if cfg.DiffFile != "" {
+		if err := writeDiffFile(cfg.DiffFile, tag, diffs); err != nil {
+			return err
+		}
+		generated = append(generated, fmt.Sprintf("%s (%d sets compared)", cfg.DiffFile, len(diffs)))
+	}
+	for name, n := range st.Counts {
+		stats.recordSet(name, n)
+	}
+	for _, set := range sets {
+		sum.Counts[set.Name] = st.Counts[set.Name]
+	}
+
+	if cfg.CountryMetadataFile != "" {
+		if err := writeCountryMetadata(cfg.CountryMetadataFile, countryNames); err != nil {
+			return err
+		}
+		generated = append(generated, fmt.Sprintf("%s (%d countries)", cfg.CountryMetadataFile, len(countryNames)))
+	}
+	if len(generated) > 0 {
+		logInfo("Generated:")
+	}
+	for _, line := range generated {
+		logInfo("- " + line)
+	}
+
+	// 7. Reload nftables
+	if cfg.DryRun {
+		sum.Reload = "skipped: -dry-run"
+	} else if cfg.NoReload {
+		logInfo("Skipping the reload (-no-reload).")
+		sum.Reload = "skipped: -no-reload"
+	} else if len(written) == 0 {
+		logInfo("No set changed beyond -min-change-threshold, skipping the nftables reload.")
+		sum.Reload = "skipped: no set changed beyond -min-change-threshold"
+	} else if !cfg.AlwaysReload && maps.Equal(fileHashes(reloadedFiles(cfg)), installed) {
+		logInfo("The generated files are identical to the installed ones, no change to reload.")
+		sum.Reload = "skipped: no change"
+	} else if len(reloadNames(cfg)) == 0 {
+		logInfo("The configured backends need no reload and -reload-cmd is not set, nothing to reload.")
+		sum.Reload = "skipped: nothing to reload"
+	} else if cfg.ReloadCmd == "" && os.Getuid() != 0 {
+		logWarn("Not running as root, skipping the nftables reload (set -reload-cmd, e.g. \"sudo nft -f /etc/nftables.conf\")")
+		sum.Reload = "skipped: not running as root"
+	} else {
+		logInfo("Reloading (" + strings.Join(reloadNames(cfg), ", ") + ")...")
+		// A timed out reload may have left anything loaded; with a backup
+		// the previous files are put back and loaded instead.
+		var restore func() error
+		if cfg.Backups > 0 {
+			restore = func() error { return restoreLatestBackup(cfg) }
+		}
+		if err := reloadAll(ctx, cfg, restore); err != nil {
+			sum.Reload = "failed: " + err.Error()
+			return err
+		}
+		sum.Reload = "ok"
+	}
+
+	st.Tag = tag
+	if err := saveState(cfg.StateFile, st); err != nil {
+		return err
+	}
+
+	// 8. Run the post-update hooks
+	if len(written) > 0 {
+		if err := runHooks(cfg, sum); err != nil {
+			return err
+		}
+	} else if len(cfg.PostHooks) > 0 {
+		logInfo("No set was written, skipping the post-update hooks.")
+	}
+
+	if unchanged && cfg.ReportUnchanged {
+		printReport(buildReport(cfg, tag), cfg.hasOutputFormat("json"))
+	}
+
+	logInfo("Done.")
+	return nil
+}
+
+// walkNetworks passes every network of the -country codes in db to emit, with
+// its country and family ("ipv4" or "ipv6"), and returns the localized names
+// of the matched countries. The MMDB is read once however many countries are
+// selected. With -continent the networks are matched on their continent
+// instead. A network inside a -subdivision is also passed with the
+// subdivisionGroup as its country, so it lands in the sets of both.
+//
+// With -invert every network outside the -country codes is passed instead,
+// including networks without a country, all as the invertedGroup set.
+//
+// The walk stops early once ctx is done.
+func walkNetworks(ctx context.Context, db *maxminddb.Reader, cfg *Config, emit func(country, family, cidr string)) map[string]string {
+	names := map[string]string{}
+	wanted := map[string]bool{}
+	for _, cc := range cfg.countries() {
+		wanted[cc] = true
+	}
+	for _, g := range cfg.subdivisions() {
+		wanted[g] = true
+	}
+	group := invertedGroup(cfg)
+	families := cfg.families()
+
+	// Iterate over all networks. The IPv6 ranges the MMDB maps onto its IPv4
+	// data (IPv4-mapped, IPv4-compatible, Teredo and 6to4) are skipped, so
+	// they can't list IPv4 space a second time, inverted sets included.
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() && ctx.Err() == nil {
+		network, rec, err := nextRecord(cfg.source(), networks)
+		if err != nil {
+			continue
+		}
+
+		var groups []string
+		cc, localized := rec.Country.ISOCode, rec.Country.Names
+		if cfg.Continent != "" {
+			cc, localized = rec.Continent.Code, rec.Continent.Names
+		}
+		if wanted[cc] != cfg.Invert {
+			if _, ok := names[cc]; !ok && cc != "" {
+				names[cc] = localizedName(localized, cfg.Lang)
+			}
+			if cfg.Invert {
+				cc = group
+			}
+			groups = append(groups, cc)
+		}
+		for _, sub := range rec.Subdivisions {
+			if g := subdivisionGroup(rec.Country.ISOCode, sub.ISOCode); wanted[g] {
+				if _, ok := names[g]; !ok {
+					names[g] = localizedName(sub.Names, cfg.Lang)
+				}
+				groups = append(groups, g)
+			}
+		}
+		if len(groups) == 0 {
+			continue
+		}
+
+		ipNet, err := normalizeNetwork(network)
+		if err != nil {
+			continue
+		}
+
+		family := "ipv6"
+		if ipNet.IP.To4() != nil {
+			family = "ipv4"
+		}
+		if slices.Contains(families, family) {
+			for _, g := range groups {
+				emit(g, family, ipNet.String())
+			}
+		}
+	}
+	return names
+}
+
+// streamSets writes every set while walk produces its elements, so no
+// element list is held in memory. It returns the number of elements written
+// to each set and the country names reported by walk.
+func streamSets(ctx context.Context, cfg *Config, sets []setSpec, walk func(emit func(country, family, cidr string)) map[string]string) (map[string]int, map[string]string, error) {
+	style, err := cfg.nftStyle()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type result struct {
+		name  string
+		count int
+		err   error
+	}
+	results := make(chan result, len(sets))
+	chans := map[string]chan string{}
+	for _, set := range sets {
+		ch := make(chan string, 256)
+		chans[set.Country+set.Family] = ch
+		go func() {
+			n, err := writeSetFile(ctx, set.Path, set, ch, style)
+			results <- result{set.Name, n, err}
+		}()
+	}
+
+	names := walk(func(country, family, cidr string) {
+		if ch, ok := chans[country+family]; ok {
+			ch <- cidr
+		}
+	})
+	for _, ch := range chans {
+		close(ch)
+	}
+
+	counts := map[string]int{}
+	for range sets {
+		r := <-results
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		counts[r.name] = r.count
+	}
+	return counts, names, err
+}
+
+func absDiff(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the v2ray GeoIPList, GeoIP and CIDR messages
+// (app/router/config.proto in v2ray-core).
+const (
+	geoIPListEntry   = 1
+	geoIPCountryCode = 1
+	geoIPCIDR        = 2
+	cidrIP           = 1
+	cidrPrefix       = 2
+)
+
+// writeV2rayGeoIP writes every set into a v2ray geoip.dat, with one GeoIP
+// entry per country holding the prefixes of all its sets. Inbound and
+// routing rules then refer to them as geoip:cn.
+func writeV2rayGeoIP(cfg *Config, path string, sets []setSpec) error {
+	var countries []string
+	byCountry := map[string][]setSpec{}
+	for _, s := range sets {
+		if _, ok := byCountry[s.Country]; !ok {
+			countries = append(countries, s.Country)
+		}
+		byCountry[s.Country] = append(byCountry[s.Country], s)
+	}
+
+	var list []byte
+	for _, country := range countries {
+		entry := protowire.AppendTag(nil, geoIPCountryCode, protowire.BytesType)
+		entry = protowire.AppendString(entry, strings.ToUpper(country))
+		for _, s := range byCountry[country] {
+			prefixes, err := elementPrefixes(s)
+			if err != nil {
+				return err
+			}
+			for _, p := range prefixes {
+				var cidr []byte
+				cidr = protowire.AppendTag(cidr, cidrIP, protowire.BytesType)
+				cidr = protowire.AppendBytes(cidr, p.Addr().AsSlice())
+				cidr = protowire.AppendTag(cidr, cidrPrefix, protowire.VarintType)
+				cidr = protowire.AppendVarint(cidr, uint64(p.Bits()))
+				entry = protowire.AppendTag(entry, geoIPCIDR, protowire.BytesType)
+				entry = protowire.AppendBytes(entry, cidr)
+			}
+		}
+		list = protowire.AppendTag(list, geoIPListEntry, protowire.BytesType)
+		list = protowire.AppendBytes(list, entry)
+	}
+	return writeFileAtomic(path, list)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// nftListOutput is the subset of `nft -j list set` output we care about.
+type nftListOutput struct {
+	Nftables []struct {
+		Set *struct {
+			Name string            `json:"name"`
+			Elem []json.RawMessage `json:"elem"`
+		} `json:"set"`
+	} `json:"nftables"`
+}
+
+func runVerifyLive(args []string) error {
+	var sample int
+	cfg, _, err := loadConfig("verify-live", args, func(c *Config, fs *flag.FlagSet) {
+		c.bindFlags(fs)
+		fs.IntVar(&sample, "sample", 100, "number of random entries to compare in each direction")
+	})
+	if err != nil {
+		return err
+	}
+	if err := cfg.apply(); err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, s := range generatedSets(cfg) {
+		ok, err := verifyLiveSet(cfg, s.Name, s.Path, sample)
+		if err != nil {
+			return err
+		}
+		failed = failed || !ok
+	}
+	if failed {
+		return fmt.Errorf("live nftables sets differ from the generated files")
+	}
+	logInfo("Live nftables sets match the generated files.")
+	return nil
+}
+
+// verifyLiveSet compares one loaded set against its generated file and logs
+// every difference found.
+func verifyLiveSet(cfg *Config, name, path string, sample int) (bool, error) {
+	fileElems, err := readSetFile(path)
+	if err != nil {
+		return false, err
+	}
+	want, err := normalizeElems(fileElems)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	live, err := listLiveSet(cfg, name)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	if len(live) != len(want) {
+		logWarn(fmt.Sprintf("set %s: %d live elements, %d in %s", name, len(live), len(want), path))
+		ok = false
+	}
+	for _, e := range randomSample(want, sample) {
+		if !live[e] {
+			logWarn(fmt.Sprintf("set %s: %s is in %s but not loaded", name, e, path))
+			ok = false
+		}
+	}
+	for _, e := range randomSample(live, sample) {
+		if !want[e] {
+			logWarn(fmt.Sprintf("set %s: %s is loaded but not in %s", name, e, path))
+			ok = false
+		}
+	}
+	if ok {
+		logInfo(fmt.Sprintf("set %s: %d elements match %s", name, len(want), path))
+	}
+	return ok, nil
+}
+
+// listLiveSet returns the elements of the set name loaded in -nft-table, inside
+// -netns, as normalized CIDRs.
+func listLiveSet(cfg *Config, name string) (map[string]bool, error) {
+	args := append(netnsPrefix(cfg.Netns), "nft", "-j", "list", "set")
+	args = append(append(args, strings.Fields(cfg.NftTable)...), name)
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("nft list set %s: %s", name, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, err
+	}
+
+	var parsed nftListOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("parse nft output: %w", err)
+	}
+
+	elems := map[string]bool{}
+	for _, item := range parsed.Nftables {
+		if item.Set == nil {
+			continue
+		}
+		for _, raw := range item.Set.Elem {
+			prefixes, err := parseNftElem(raw)
+			if err != nil {
+				return nil, fmt.Errorf("set %s: %w", name, err)
+			}
+			for _, p := range prefixes {
+				elems[p.String()] = true
+			}
+		}
+	}
+	return elems, nil
+}
+
+// parseNftElem decodes one element of nft JSON output: a bare address, a
+// {"prefix": ...}, a {"range": [...]} or an {"elem": ...} wrapper.
+func parseNftElem(raw json.RawMessage) ([]netip.Prefix, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		return []netip.Prefix{p}, nil
+	}
+
+	var obj struct {
+		Prefix *struct {
+			Addr string `json:"addr"`
+			Len  int    `json:"len"`
+		} `json:"prefix"`
+		Range []string `json:"range"`
+		Elem  *struct {
+			Val json.RawMessage `json:"val"`
+		} `json:"elem"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	switch {
+	case obj.Prefix != nil:
+		p, err := parsePrefix(fmt.Sprintf("%s/%d", obj.Prefix.Addr, obj.Prefix.Len))
+		if err != nil {
+			return nil, err
+		}
+		return []netip.Prefix{p}, nil
+	case len(obj.Range) == 2:
+		from, err := netip.ParseAddr(obj.Range[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := netip.ParseAddr(obj.Range[1])
+		if err != nil {
+			return nil, err
+		}
+		return rangeToPrefixes(from, to), nil
+	case obj.Elem != nil:
+		return parseNftElem(obj.Elem.Val)
+	}
+	return nil, fmt.Errorf("unsupported element %s", raw)
+}
+
+func normalizeElems(elems []string) (map[string]bool, error) {
+	out := make(map[string]bool, len(elems))
+	for _, e := range elems {
+		p, err := parsePrefix(e)
+		if err != nil {
+			return nil, err
+		}
+		out[p.String()] = true
+	}
+	return out, nil
+}
+
+// randomSample returns up to n random keys of m.
+func randomSample(m map[string]bool, n int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// webhookPayload is the JSON body posted to -webhook-url.
+type webhookPayload struct {
+	Host     string         `json:"host"`
+	Start    time.Time      `json:"start"`
+	Duration float64        `json:"duration_seconds"`
+	Success  bool           `json:"success"`
+	Tag      string         `json:"tag,omitempty"`
+	Changed  bool           `json:"changed"`
+	Counts   map[string]int `json:"counts"`
+	Previous map[string]int `json:"previous"`
+	Reload   string         `json:"reload,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// webhookBackoff is the delay before the first retry of a failed webhook; it
+// doubles on every further attempt.
+const webhookBackoff = 2 * time.Second
+
+// sendWebhook posts s as JSON to -webhook-url, trying again up to
+// -webhook-retries times.
+func sendWebhook(cfg *Config, s *runSummary) error {
+	p := webhookPayload{
+		Host:     s.Host,
+		Start:    s.Start.UTC(),
+		Duration: s.Duration.Seconds(),
+		Success:  s.Err == nil,
+		Tag:      s.Tag,
+		Changed:  s.Changed,
+		Counts:   s.Counts,
+		Previous: s.Previous,
+		Reload:   s.Reload,
+	}
+	if s.Err != nil {
+		p.Error = s.Err.Error()
+	}
+	delay := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := postJSON(cfg.WebhookURL, p)
+		if err == nil {
+			return nil
+		}
+		if attempt >= cfg.WebhookRetries {
+			return fmt.Errorf("%w (after %d attempts)", err, attempt+1)
+		}
+		logDebug(fmt.Sprintf("Webhook failed: %v, retrying in %s", err, delay))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}